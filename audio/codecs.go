@@ -0,0 +1,110 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+)
+
+// Maps ffmpeg decoder names to the file suffixes they are able to play.
+var CodecSuffixes = map[string][]string{
+	`aac`:       {`aac`, `m4a`, `mp4`},
+	`ac3`:       {`ac3`},
+	`alac`:      {`m4a`},
+	`ape`:       {`ape`},
+	`dsd_lsbf`:  {`dsf`},
+	`dsd_msbf`:  {`dff`},
+	`dts`:       {`dts`},
+	`flac`:      {`flac`, `fla`},
+	`mp2`:       {`mp2`},
+	`mp3`:       {`mp3`},
+	`mp3float`:  {`mp3`},
+	`opus`:      {`opus`},
+	`pcm_s16be`: {`aif`, `aiff`, `aifc`},
+	`pcm_s16le`: {`wav`},
+	`shorten`:   {`shn`},
+	`tta`:       {`tta`},
+	`vorbis`:    {`ogg`, `oga`},
+	`wavpack`:   {`wv`},
+	`wmav1`:     {`wma`},
+	`wmav2`:     {`wma`},
+}
+
+// Describes what the decoder is able to play on this system.
+type Capabilities struct {
+	Codecs    []string
+	Suffixes  []string
+	MimeTypes []string
+}
+
+var capabilities *Capabilities
+var capabilitiesErr error
+var capabilitiesOnce sync.Once
+
+// Returns the codecs, file suffixes, and MIME types that the installed ffmpeg is able to decode.
+// The result is determined once and cached for the lifetime of the process.
+func GetCapabilities() (*Capabilities, error) {
+	capabilitiesOnce.Do(func() {
+		capabilities, capabilitiesErr = probeCapabilities()
+	})
+
+	return capabilities, capabilitiesErr
+}
+
+func probeCapabilities() (*Capabilities, error) {
+	output, err := exec.Command(FFmpegCommandName, `-hide_banner`, `-decoders`).Output()
+
+	if err != nil {
+		return nil, err
+	}
+
+	caps := new(Capabilities)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	var inList bool
+
+	// the list of decoders follows a line of dashes, and each line looks like:
+	//  A....D mp3float             MP3 (MPEG audio layer 3)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, `---`) {
+			inList = true
+			continue
+		} else if !inList {
+			continue
+		}
+
+		if fields := strings.Fields(line); len(fields) >= 2 && strings.HasPrefix(fields[0], `A`) {
+			codec := fields[1]
+
+			if suffixes, ok := CodecSuffixes[codec]; ok {
+				caps.Codecs = append(caps.Codecs, codec)
+
+				for _, suffix := range suffixes {
+					caps.Suffixes = append(caps.Suffixes, suffix)
+
+					if mt := mime.TypeByExtension(`.` + suffix); mt != `` {
+						if mediaType, _, _ := mime.ParseMediaType(mt); mediaType != `` {
+							caps.MimeTypes = append(caps.MimeTypes, mediaType)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	caps.Suffixes = sliceutil.UniqueStrings(caps.Suffixes)
+	caps.MimeTypes = sliceutil.UniqueStrings(caps.MimeTypes)
+
+	sort.Strings(caps.Codecs)
+	sort.Strings(caps.Suffixes)
+	sort.Strings(caps.MimeTypes)
+
+	return caps, scanner.Err()
+}
@@ -0,0 +1,291 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/moped/library"
+)
+
+var FFmpegCommandName = `ffmpeg`
+
+// maps supported bit depths to the ffmpeg raw format and codec that produce them
+var sampleFormats = map[int][2]string{
+	8:  {`s8`, `pcm_s8`},
+	16: {`s16le`, `pcm_s16le`},
+	24: {`s24le`, `pcm_s24le`},
+	32: {`s32le`, `pcm_s32le`},
+}
+
+type DecodeOptions struct {
+	// The PCM format to decode to; unset fields are negotiated (see Negotiate).
+	Format Format
+
+	// The position in the source to start decoding from.
+	Offset time.Duration
+
+	// If non-zero, the position in the source to stop decoding at.
+	End time.Duration
+}
+
+// A Stream is an io.Reader of raw PCM audio being decoded from a source by ffmpeg.
+type Stream struct {
+	Format  Format
+	End     time.Duration
+	base    time.Duration
+	source  io.Reader
+	parent  context.Context
+	cancel  context.CancelFunc
+	proc    *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  bytes.Buffer
+	offset  time.Duration
+	read    int64
+	started bool
+	lock    sync.Mutex
+}
+
+// Starts decoding the given source (typically a *library.Entry) into raw PCM.  Decoding stops when
+// the source is exhausted, when the stream is closed, or when the given context is cancelled.
+//
+// When the source is an entry that is only part of a file (such as a track of a cue sheet), it is
+// decoded as though the rest of the file wasn't there: offsets and positions are relative to the
+// start of the segment, and decoding stops at the end of it.
+func Decode(ctx context.Context, source io.Reader, options DecodeOptions) (*Stream, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	stream := &Stream{
+		Format: Negotiate(Format{}, options.Format),
+		End:    options.End,
+		source: source,
+		parent: ctx,
+	}
+
+	if entry, ok := source.(*library.Entry); ok && entry.Segment != nil {
+		stream.base = entry.Segment.Start
+
+		if length := entry.Segment.End - entry.Segment.Start; entry.Segment.End > 0 {
+			if stream.End <= 0 || stream.End > length {
+				stream.End = length
+			}
+		}
+	}
+
+	if err := stream.start(options.Offset); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+func (self *Stream) Read(b []byte) (int, error) {
+	self.lock.Lock()
+	stdout := self.stdout
+	self.lock.Unlock()
+
+	if stdout == nil {
+		return 0, io.EOF
+	}
+
+	n, err := stdout.Read(b)
+
+	self.lock.Lock()
+	self.read += int64(n)
+	self.lock.Unlock()
+
+	if err == io.EOF {
+		if werr := self.wait(); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// Returns the position in the source of the next sample that will be read from the stream.
+func (self *Stream) Position() time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.offset + self.Format.Duration(self.read)
+}
+
+// Seeks to the given position by restarting the decoder at that offset.  This requires that the
+// source be a local file or a URL, or an io.Seeker so that it can be rewound.
+func (self *Stream) Seek(offset time.Duration) error {
+	if _, ok := directInput(self.source); ok {
+		// the decoder reads the file (or URL) itself
+	} else if _, ok := self.source.(io.Seeker); !ok {
+		return fmt.Errorf("Cannot seek: source %T is not seekable", self.source)
+	}
+
+	self.stop()
+
+	return self.start(offset)
+}
+
+// Stops decoding and releases the decoder process.
+func (self *Stream) Close() error {
+	self.stop()
+	return nil
+}
+
+func (self *Stream) start(offset time.Duration) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	rawfmt, ok := sampleFormats[self.Format.Bits]
+
+	if !ok {
+		return fmt.Errorf("Unsupported bit depth %d", self.Format.Bits)
+	}
+
+	// there is nothing left to decode at or past the end, so the stream is already exhausted (and
+	// reads return io.EOF) rather than running on past it
+	if self.End > 0 && offset >= self.End {
+		self.offset = offset
+		self.read = 0
+		self.started = true
+		return nil
+	}
+
+	// local files are read by ffmpeg itself, so that seeking skips straight to the offset instead
+	// of decoding (and throwing away) everything before it; so are URLs, which have no other way
+	// of being read
+	input, direct := directInput(self.source)
+
+	if !direct {
+		input = `pipe:0`
+
+		if self.started {
+			if seeker, ok := self.source.(io.Seeker); ok {
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return fmt.Errorf("Cannot rewind source: %v", err)
+				}
+			}
+		}
+	}
+
+	args := []string{
+		`-hide_banner`,
+		`-v`, `error`,
+	}
+
+	if offset+self.base > 0 {
+		args = append(args, `-ss`, formatSeconds(offset+self.base))
+	}
+
+	if self.End > 0 {
+		args = append(args, `-t`, formatSeconds(self.End-offset))
+	}
+
+	args = append(args,
+		`-i`, input,
+		`-vn`,
+		`-f`, rawfmt[0],
+		`-acodec`, rawfmt[1],
+		`-ar`, fmt.Sprintf("%d", self.Format.SampleRate),
+		`-ac`, fmt.Sprintf("%d", self.Format.Channels),
+		`pipe:1`,
+	)
+
+	ctx, cancel := context.WithCancel(self.parent)
+
+	proc := exec.CommandContext(ctx, FFmpegCommandName, args...)
+
+	if !direct {
+		proc.Stdin = self.source
+	}
+	proc.Env = []string{
+		`AV_LOG_FORCE_NOCOLOR=1`,
+	}
+
+	self.stderr.Reset()
+	proc.Stderr = &self.stderr
+
+	stdout, err := proc.StdoutPipe()
+
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	if err := proc.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("decoder: %v", err)
+	}
+
+	self.proc = proc
+	self.stdout = stdout
+	self.cancel = cancel
+	self.offset = offset
+	self.read = 0
+	self.started = true
+
+	return nil
+}
+
+func (self *Stream) stop() {
+	self.lock.Lock()
+	cancel := self.cancel
+	self.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		self.wait()
+	}
+}
+
+func (self *Stream) wait() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.proc == nil {
+		return nil
+	}
+
+	proc := self.proc
+	cancelled := (self.parent.Err() != nil)
+
+	self.proc = nil
+	self.stdout = nil
+
+	err := proc.Wait()
+	self.cancel()
+	self.cancel = nil
+
+	if err != nil && !cancelled {
+		if msg := strings.TrimSpace(self.stderr.String()); msg != `` {
+			return fmt.Errorf("decoder: %v", msg)
+		} else if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("decoder: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// returns the local file or URL that ffmpeg should read the given source from, if any
+func directInput(source io.Reader) (string, bool) {
+	if entry, ok := source.(*library.Entry); ok {
+		if entry.IsRemote() {
+			return entry.Path, true
+		}
+
+		return entry.LocalPath()
+	}
+
+	return ``, false
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.6f", d.Seconds())
+}
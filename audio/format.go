@@ -0,0 +1,162 @@
+package audio
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghetzel/moped/library"
+)
+
+// The format used for any aspect of a negotiation that neither side has an opinion on.
+var DefaultFormat = Format{
+	SampleRate: 44100,
+	Bits:       16,
+	Channels:   2,
+}
+
+// Describes a stream of raw, interleaved, little-endian signed PCM samples.
+type Format struct {
+	SampleRate int `json:"samplerate"`
+	Bits       int `json:"bits"`
+	Channels   int `json:"channels"`
+}
+
+// Parses a format string in MPD's "samplerate:bits:channels" notation.  Any of the fields may be
+// given as "*", which leaves that field unset (to be filled in by Negotiate).
+func ParseFormat(spec string) (Format, error) {
+	var format Format
+
+	parts := strings.Split(spec, `:`)
+
+	if len(parts) != 3 {
+		return format, fmt.Errorf("Invalid audio format %q: expected samplerate:bits:channels", spec)
+	}
+
+	for i, part := range parts {
+		if part == `*` {
+			continue
+		}
+
+		if v, err := stringutil.ConvertToInteger(part); err == nil && v > 0 {
+			switch i {
+			case 0:
+				format.SampleRate = int(v)
+			case 1:
+				format.Bits = int(v)
+			case 2:
+				format.Channels = int(v)
+			}
+		} else {
+			return format, fmt.Errorf("Invalid audio format %q", spec)
+		}
+	}
+
+	if format.Bits > 0 {
+		if _, ok := sampleFormats[format.Bits]; !ok {
+			return format, fmt.Errorf("Unsupported bit depth %d", format.Bits)
+		}
+	}
+
+	return format, nil
+}
+
+// Returns the format that the given entry's audio is natively encoded in, to the extent that it
+// is known from the entry's metadata.
+func FormatOfEntry(entry *library.Entry) Format {
+	var format Format
+
+	if extra := entry.Metadata.Extra; extra != nil {
+		format.SampleRate = int(typeutil.Int(extra[`samplerate`]))
+		format.Channels = int(typeutil.Int(extra[`channels`]))
+	}
+
+	return format
+}
+
+// Negotiates the format to decode to.  Fields set in requested take precedence, any that are
+// unset are taken from the source format, and anything still unset comes from DefaultFormat.
+func Negotiate(source Format, requested Format) Format {
+	format := requested
+
+	if format.SampleRate <= 0 {
+		format.SampleRate = source.SampleRate
+	}
+
+	if format.Bits <= 0 {
+		if _, ok := sampleFormats[source.Bits]; ok {
+			format.Bits = source.Bits
+		}
+	}
+
+	if format.Channels <= 0 {
+		format.Channels = source.Channels
+	}
+
+	if format.SampleRate <= 0 {
+		format.SampleRate = DefaultFormat.SampleRate
+	}
+
+	if format.Bits <= 0 {
+		format.Bits = DefaultFormat.Bits
+	}
+
+	if format.Channels <= 0 {
+		format.Channels = DefaultFormat.Channels
+	}
+
+	return format
+}
+
+func (self Format) IsZero() bool {
+	return (self.SampleRate == 0 && self.Bits == 0 && self.Channels == 0)
+}
+
+// Returns the number of bytes in a single sample (for one channel).
+func (self Format) SampleSize() int {
+	return self.Bits / 8
+}
+
+// Returns the number of bytes in a single frame (one sample for every channel).
+func (self Format) FrameSize() int {
+	return self.SampleSize() * self.Channels
+}
+
+func (self Format) BytesPerSecond() int {
+	return self.FrameSize() * self.SampleRate
+}
+
+// Returns how long it takes to play back the given number of bytes.
+func (self Format) Duration(bytes int64) time.Duration {
+	if bps := self.BytesPerSecond(); bps > 0 {
+		return time.Duration(float64(bytes) / float64(bps) * float64(time.Second))
+	}
+
+	return 0
+}
+
+// Returns the number of bytes it takes to hold the given duration of audio, rounded down to a
+// whole number of frames.
+func (self Format) Bytes(duration time.Duration) int64 {
+	if frame := int64(self.FrameSize()); frame > 0 {
+		bytes := int64(duration.Seconds() * float64(self.BytesPerSecond()))
+		return bytes - (bytes % frame)
+	}
+
+	return 0
+}
+
+// Returns the name ffmpeg uses for raw PCM in this format's sample size.
+func (self Format) SampleFormat() string {
+	if f, ok := sampleFormats[self.Bits]; ok {
+		return f[0]
+	}
+
+	return ``
+}
+
+func (self Format) String() string {
+	return fmt.Sprintf("%d:%d:%d", self.SampleRate, self.Bits, self.Channels)
+}
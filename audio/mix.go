@@ -0,0 +1,103 @@
+package audio
+
+import (
+	"math"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+)
+
+// Mixes the outgoing audio in src into the incoming audio in dst (in place), as one step of a
+// crossfade.  The crossfade's progress (0 being all src, 1 being all dst) moves linearly from
+// "from" to "to" over the course of the buffer.  Both buffers must be in the given format; if src
+// is shorter than dst, the rest of dst is left as-is.
+func Crossfade(format Format, dst []byte, src []byte, from float64, to float64) {
+	mix(format, dst, src, func(frame int, frames int) (float64, float64) {
+		progress := math.Min(math.Max(from+(to-from)*(float64(frame)/float64(frames)), 0), 1)
+		return progress, 1 - progress
+	})
+}
+
+// Adds the audio in src to the audio in dst (in place), clipping the result.
+func Mix(format Format, dst []byte, src []byte) {
+	mix(format, dst, src, func(int, int) (float64, float64) {
+		return 1, 1
+	})
+}
+
+func mix(format Format, dst []byte, src []byte, gains func(frame int, frames int) (float64, float64)) {
+	size := format.SampleSize()
+	framesize := format.FrameSize()
+
+	if framesize == 0 {
+		return
+	}
+
+	if len(src) > len(dst) {
+		src = src[:len(dst)]
+	}
+
+	max := float64(int64(1)<<uint(format.Bits-1)) - 1
+	min := -max - 1
+	frames := len(dst) / framesize
+
+	for f := 0; (f+1)*framesize <= len(src); f++ {
+		dstGain, srcGain := gains(f, frames)
+
+		for i := f * framesize; i < (f+1)*framesize; i += size {
+			sample := float64(readSample(dst[i:i+size]))*dstGain + float64(readSample(src[i:i+size]))*srcGain
+
+			if sample > max {
+				sample = max
+			} else if sample < min {
+				sample = min
+			}
+
+			writeSample(dst[i:i+size], int64(math.Round(sample)))
+		}
+	}
+}
+
+// Finds the point in a MixRamp profile (as found in the MIXRAMP_START and MIXRAMP_END tags) at
+// which the volume reaches the given threshold (in dB).  A profile is a list of "dB seconds" pairs
+// separated by semicolons, ordered by increasing volume.  The result (in seconds) is interpolated
+// between the two closest points in the profile, or is -1 if the threshold is never reached.
+func MixRampInterpolate(ramp string, threshold float64) float64 {
+	var lastDB, lastSeconds float64
+	var haveLast bool
+
+	for _, point := range strings.Split(ramp, `;`) {
+		dbValue, secondsValue := stringutil.SplitPair(strings.TrimSpace(point), ` `)
+
+		if dbValue == `` || secondsValue == `` {
+			continue
+		}
+
+		db, err := stringutil.ConvertToFloat(dbValue)
+
+		if err != nil {
+			return -1
+		}
+
+		seconds, err := stringutil.ConvertToFloat(strings.TrimSpace(secondsValue))
+
+		if err != nil {
+			return -1
+		}
+
+		if db < threshold {
+			lastDB = db
+			lastSeconds = seconds
+			haveLast = true
+			continue
+		}
+
+		if !haveLast || db == lastDB {
+			return seconds
+		}
+
+		return lastSeconds + (threshold-lastDB)*(seconds-lastSeconds)/(db-lastDB)
+	}
+
+	return -1
+}
@@ -0,0 +1,82 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ghetzel/moped/library"
+)
+
+type ReplayGainMode string
+
+const (
+	ReplayGainOff   ReplayGainMode = `off`
+	ReplayGainTrack ReplayGainMode = `track`
+	ReplayGainAlbum ReplayGainMode = `album`
+	ReplayGainAuto  ReplayGainMode = `auto`
+)
+
+func ParseReplayGainMode(value string) (ReplayGainMode, error) {
+	switch mode := ReplayGainMode(value); mode {
+	case ReplayGainOff, ReplayGainTrack, ReplayGainAlbum, ReplayGainAuto:
+		return mode, nil
+	case ``:
+		return ReplayGainOff, nil
+	default:
+		return ReplayGainOff, fmt.Errorf("Unrecognized replay gain mode %q", value)
+	}
+}
+
+// Settings that control how ReplayGain values are turned into a volume adjustment.
+type ReplayGain struct {
+	// An adjustment (in dB) made on top of the ReplayGain value of every song that has one.
+	Preamp float64 `json:"preamp"`
+
+	// The adjustment (in dB) made to songs that have no ReplayGain values.
+	MissingPreamp float64 `json:"missing_preamp"`
+
+	// Whether to use the peak value to keep the gain from pushing a song into clipping.
+	Limit bool `json:"limit"`
+}
+
+// Returns the linear scale factor to apply to a song with the given ReplayGain values (which may
+// be nil if the song has none).
+func (self ReplayGain) Scale(tuple *library.ReplayGainTuple) float64 {
+	if tuple == nil {
+		return math.Pow(10, self.MissingPreamp/20)
+	}
+
+	scale := math.Pow(10, (tuple.Gain+self.Preamp)/20)
+
+	if self.Limit && tuple.Peak > 0 && scale*tuple.Peak > 1 {
+		scale = 1 / tuple.Peak
+	}
+
+	return scale
+}
+
+// Picks which of a song's ReplayGain values to use in the given mode, falling back to the other
+// one if the preferred one is missing.  The auto mode should be resolved to either track or album
+// mode before calling this.
+func SelectReplayGain(info *library.ReplayGain, mode ReplayGainMode) *library.ReplayGainTuple {
+	if info == nil {
+		return nil
+	}
+
+	switch mode {
+	case ReplayGainTrack:
+		if info.Track != nil {
+			return info.Track
+		}
+
+		return info.Album
+	case ReplayGainAlbum:
+		if info.Album != nil {
+			return info.Album
+		}
+
+		return info.Track
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package audio
+
+import (
+	"math"
+)
+
+// Converts a volume level (0-100) into a linear gain factor.  The curve is exponential rather than
+// linear so that each step of a volume slider sounds like roughly the same change in loudness.
+func VolumeToGain(volume int) float64 {
+	if volume >= 100 {
+		return 1
+	} else if volume <= 0 {
+		return 0
+	}
+
+	return (math.Exp(float64(volume)/25.0) - 1) / (math.Exp(4) - 1)
+}
+
+// Scales every sample in data (which must be in the given format) by gain, in place.  Samples that
+// would overflow are clipped.
+func ApplyGain(format Format, data []byte, gain float64) {
+	if gain == 1 {
+		return
+	}
+
+	size := format.SampleSize()
+
+	if size == 0 {
+		return
+	}
+
+	max := float64(int64(1)<<uint(format.Bits-1)) - 1
+	min := -max - 1
+
+	for i := 0; i+size <= len(data); i += size {
+		sample := float64(readSample(data[i:i+size])) * gain
+
+		if sample > max {
+			sample = max
+		} else if sample < min {
+			sample = min
+		}
+
+		writeSample(data[i:i+size], int64(math.Round(sample)))
+	}
+}
+
+// reads a little-endian signed integer of len(b) bytes
+func readSample(b []byte) int64 {
+	var v uint64
+
+	for i := len(b) - 1; i >= 0; i-- {
+		v = (v << 8) | uint64(b[i])
+	}
+
+	// sign-extend from the sample width to 64 bits
+	shift := uint(64 - 8*len(b))
+
+	return int64(v<<shift) >> shift
+}
+
+// writes v as a little-endian signed integer of len(b) bytes
+func writeSample(b []byte, v int64) {
+	for i := range b {
+		b[i] = byte(v >> uint(8*i))
+	}
+}
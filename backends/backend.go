@@ -0,0 +1,69 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/moped/library"
+)
+
+// A Factory creates a new library from the "config" section of a library's configuration, which
+// it is responsible for decoding and validating.
+type Factory func(config map[string]interface{}) (library.Library, error)
+
+var factories = make(map[string]Factory)
+var factoryLock sync.RWMutex
+
+// Registers a factory for creating libraries of the given type.  It panics if the factory is nil,
+// or if a factory has already been registered for the type.
+func Register(backendType string, factory Factory) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+
+	if factory == nil {
+		panic(`backends: Register factory is nil`)
+	} else if _, dup := factories[backendType]; dup {
+		panic(`backends: Register called twice for type ` + backendType)
+	}
+
+	factories[backendType] = factory
+}
+
+// Returns the names of all registered library types.
+func Types() []string {
+	factoryLock.RLock()
+	defer factoryLock.RUnlock()
+
+	types := maputil.StringKeys(factories)
+	sort.Strings(types)
+
+	return types
+}
+
+// Creates a new library of the given type.
+func New(backendType string, config map[string]interface{}) (library.Library, error) {
+	factoryLock.RLock()
+	factory, ok := factories[backendType]
+	factoryLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf(
+			"Unknown library type %q (valid types are: %s)",
+			backendType,
+			strings.Join(Types(), `, `),
+		)
+	}
+
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+
+	return factory(config)
+}
+
+func configure(config map[string]interface{}, into interface{}) error {
+	return maputil.TaggedStructFromMap(config, into, `json`)
+}
@@ -0,0 +1,219 @@
+package backends
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/library"
+	"github.com/ghetzel/moped/metadata"
+)
+
+// Audio files that are split up into tracks by a cue sheet are presented as folders, in which each
+// track is a virtual entry named "track0001", "track0002", and so on.
+var cueTrackPattern = regexp.MustCompile(`^track(\d{4,})$`)
+
+// a file that a cue sheet splits into tracks
+type cueSplit struct {
+	Sheet    *metadata.CueSheet
+	File     *metadata.CueFile
+	Modified time.Time
+}
+
+// Returns the files in the given folder that are split up by cue sheets, keyed by their names.
+func (self *FilesystemBackend) cueSplits(absDir string) map[string]*cueSplit {
+	splits := make(map[string]*cueSplit)
+	infos, err := ioutil.ReadDir(absDir)
+
+	if err != nil {
+		return splits
+	}
+
+	names := make([]string, 0, len(infos))
+
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, info := range infos {
+		if info.IsDir() || strings.ToLower(path.Ext(info.Name())) != `.cue` {
+			continue
+		}
+
+		sheet, err := readCueSheet(path.Join(absDir, info.Name()))
+
+		if err != nil {
+			log.Warningf("Failed to read %v: %v", info.Name(), err)
+			continue
+		}
+
+		for _, file := range sheet.Files {
+			if name, ok := cueFileName(file.Name, names); ok && len(file.Tracks) > 0 {
+				splits[name] = &cueSplit{
+					Sheet:    sheet,
+					File:     file,
+					Modified: info.ModTime(),
+				}
+			}
+		}
+	}
+
+	return splits
+}
+
+// Returns the split (if any) of the file at the given path.
+func (self *FilesystemBackend) cueSplit(absPath string) (*cueSplit, bool) {
+	split, ok := self.cueSplits(path.Dir(absPath))[path.Base(absPath)]
+	return split, ok
+}
+
+// Returns the tracks that a cue sheet splits the given file into.
+func (self *FilesystemBackend) cueTracks(absPath string, split *cueSplit) (library.EntryList, error) {
+	if _, err := os.Stat(absPath); err != nil {
+		return nil, err
+	}
+
+	meta := self.loadMetadata(absPath)
+	entries := make(library.EntryList, len(split.File.Tracks))
+
+	for i := range split.File.Tracks {
+		entries[i] = self.cueTrackEntry(absPath, split, meta, i)
+	}
+
+	return entries, nil
+}
+
+// Returns the virtual track at the given path (e.g.: "album.flac/track0001").
+func (self *FilesystemBackend) cueTrack(absPath string) (*library.Entry, error) {
+	if match := cueTrackPattern.FindStringSubmatch(path.Base(absPath)); match != nil {
+		absFile := path.Dir(absPath)
+
+		if split, ok := self.cueSplit(absFile); ok {
+			if n, err := strconv.Atoi(match[1]); err == nil && n >= 1 && n <= len(split.File.Tracks) {
+				if _, err := os.Stat(absFile); err == nil {
+					return self.cueTrackEntry(absFile, split, self.loadMetadata(absFile), n-1), nil
+				} else {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("No such file or directory: %v", absPath)
+}
+
+// builds the entry for the i-th track of a split file, whose tags are taken from the cue sheet
+// and everything else from the file itself
+func (self *FilesystemBackend) cueTrackEntry(absFile string, split *cueSplit, meta library.Metadata, i int) *library.Entry {
+	track := split.File.Tracks[i]
+
+	meta.Title = track.Title
+	meta.Artist = split.Sheet.TrackPerformer(track)
+	meta.Album = split.Sheet.Title
+	meta.Track = track.Number
+
+	if split.Sheet.Genre != `` {
+		meta.Genre = split.Sheet.Genre
+	}
+
+	if len(split.Sheet.Date) >= 4 {
+		if year, err := strconv.Atoi(split.Sheet.Date[:4]); err == nil {
+			meta.Year = year
+		}
+	}
+
+	if track.End > 0 {
+		meta.Duration = track.End - track.Start
+	} else if meta.Duration > track.Start {
+		meta.Duration -= track.Start
+	} else {
+		meta.Duration = 0
+	}
+
+	if split.Modified.After(meta.LastModified) {
+		meta.LastModified = split.Modified
+	}
+
+	// the gain of the whole file is that of the album the tracks are from
+	if rg := meta.ReplayGain; rg != nil {
+		album := rg.Album
+
+		if album == nil {
+			album = rg.Track
+		}
+
+		meta.ReplayGain = &library.ReplayGain{
+			Album: album,
+		}
+	}
+
+	entry := &library.Entry{
+		Path:     strings.TrimPrefix(absFile, self.config.Path) + fmt.Sprintf("/track%04d", i+1),
+		Type:     library.AudioEntry,
+		Metadata: meta,
+		Segment: &library.Segment{
+			Start: track.Start,
+			End:   track.End,
+		},
+	}
+
+	entry.SetSource(library.NewLazyReader(func() (io.ReadCloser, error) {
+		log.Debugf("File open: %v (track %d)", absFile, i+1)
+		return os.Open(absFile)
+	}))
+
+	entry.SetLocalPath(absFile)
+
+	return entry
+}
+
+func readCueSheet(filename string) (*metadata.CueSheet, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return metadata.ParseCueSheet(file)
+}
+
+// Finds the file (among the given names) that a cue sheet refers to.  Cue sheets often name the
+// file an album was originally ripped to (e.g.: a .wav) rather than what it was converted to
+// later, so a file with the same name but a different extension will do.
+func cueFileName(name string, names []string) (string, bool) {
+	name = path.Base(strings.Replace(name, `\`, `/`, -1))
+	stem := strings.TrimSuffix(name, path.Ext(name))
+
+	for _, candidate := range names {
+		if candidate == name {
+			return candidate, true
+		}
+	}
+
+	for _, candidate := range names {
+		ext := path.Ext(candidate)
+
+		if strings.TrimSuffix(candidate, ext) == stem && !library.IsPlaylistFile(candidate) {
+			if strings.HasPrefix(mime.TypeByExtension(ext), `audio/`) {
+				return candidate, true
+			}
+		}
+	}
+
+	return ``, false
+}
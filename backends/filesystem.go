@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghetzel/go-stockutil/log"
@@ -20,12 +23,28 @@ import (
 
 var LocalMetadataDetail = 1
 
+func init() {
+	Register(`local`, func(config map[string]interface{}) (library.Library, error) {
+		var cfg FilesystemConfig
+
+		if err := configure(config, &cfg); err != nil {
+			return nil, err
+		}
+
+		return NewFilesystemBackend(&cfg)
+	})
+}
+
 type FilesystemConfig struct {
-	Path string `json:"path"`
+	Path     string `json:"path"`
+	Loudness bool   `json:"loudness"`
+	Watch    bool   `json:"watch"`
 }
 
 type FilesystemBackend struct {
-	config *FilesystemConfig
+	config    *FilesystemConfig
+	watcher   *filesystemWatcher
+	watchLock sync.Mutex
 }
 
 func NewFilesystemBackend(config *FilesystemConfig) (*FilesystemBackend, error) {
@@ -39,11 +58,20 @@ func NewFilesystemBackend(config *FilesystemConfig) (*FilesystemBackend, error)
 		return nil, fmt.Errorf("Must specify a path for a filesystem library")
 	}
 
+	// paths found while walking the library are made relative to this, so it can't have any
+	// trailing slashes or other redundant parts
+	config.Path = filepath.Clean(config.Path)
+
 	return &FilesystemBackend{
 		config: config,
 	}, nil
 }
 
+// Returns the file:// URI of the folder the library is in.
+func (self *FilesystemBackend) URI() string {
+	return (&url.URL{Scheme: `file`, Path: self.config.Path}).String()
+}
+
 func (self *FilesystemBackend) Ping() error {
 	if _, err := ioutil.ReadDir(self.config.Path); err != nil {
 		return err
@@ -56,6 +84,12 @@ func (self *FilesystemBackend) Browse(relativePath string) (library.EntryList, e
 	absPath := self.path(relativePath)
 
 	if pathutil.FileExists(absPath) {
+		if library.IsPlaylistFile(absPath) {
+			return self.playlistEntries(relativePath)
+		} else if split, ok := self.cueSplit(absPath); ok {
+			return self.cueTracks(absPath, split)
+		}
+
 		if entry, err := self.Get(relativePath); err == nil {
 			return library.EntryList{
 				entry,
@@ -65,9 +99,10 @@ func (self *FilesystemBackend) Browse(relativePath string) (library.EntryList, e
 		}
 	} else if infos, err := ioutil.ReadDir(absPath); err == nil {
 		entries := make(library.EntryList, 0)
+		splits := self.cueSplits(absPath)
 
 		for _, info := range infos {
-			if entry, err := self.entryFromFileInfo(path.Join(absPath, info.Name()), info); err == nil {
+			if entry, err := self.entryFromFileInfo(path.Join(absPath, info.Name()), info, splits[info.Name()]); err == nil {
 				entries = append(entries, entry)
 			} else {
 				log.Warningf("Failed to read %v: %v", info.Name(), err)
@@ -76,6 +111,61 @@ func (self *FilesystemBackend) Browse(relativePath string) (library.EntryList, e
 		}
 
 		return entries, nil
+	} else if entry, verr := self.cueTrack(absPath); verr == nil {
+		return library.EntryList{
+			entry,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Lists the contents of the given folder without loading any metadata.
+// Files that are split up by a cue sheet are listed as folders (which are considered modified
+// whenever the cue sheet is), the contents of which are their tracks.
+func (self *FilesystemBackend) List(relativePath string) ([]library.EntryInfo, error) {
+	absPath := self.path(relativePath)
+
+	if infos, err := ioutil.ReadDir(absPath); err == nil {
+		list := make([]library.EntryInfo, 0, len(infos))
+		splits := self.cueSplits(absPath)
+
+		for _, info := range infos {
+			entryInfo := library.EntryInfo{
+				Name:         info.Name(),
+				Folder:       info.IsDir(),
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			}
+
+			if split, ok := splits[info.Name()]; ok && !info.IsDir() {
+				entryInfo.Folder = true
+
+				if split.Modified.After(entryInfo.LastModified) {
+					entryInfo.LastModified = split.Modified
+				}
+			}
+
+			list = append(list, entryInfo)
+		}
+
+		return list, nil
+	} else if split, ok := self.cueSplit(absPath); ok {
+		list := make([]library.EntryInfo, len(split.File.Tracks))
+		modified := split.Modified
+
+		if info, err := os.Stat(absPath); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+
+		for i := range split.File.Tracks {
+			list[i] = library.EntryInfo{
+				Name:         fmt.Sprintf("track%04d", i+1),
+				LastModified: modified,
+			}
+		}
+
+		return list, nil
 	} else {
 		return nil, err
 	}
@@ -85,11 +175,19 @@ func (self *FilesystemBackend) Get(relativePath string) (*library.Entry, error)
 	absPath := self.path(relativePath)
 
 	if info, err := os.Stat(absPath); err == nil {
-		if entry, err := self.entryFromFileInfo(absPath, info); err == nil {
+		var split *cueSplit
+
+		if !info.IsDir() {
+			split, _ = self.cueSplit(absPath)
+		}
+
+		if entry, err := self.entryFromFileInfo(absPath, info, split); err == nil {
 			return entry, nil
 		} else {
 			return nil, err
 		}
+	} else if entry, verr := self.cueTrack(absPath); verr == nil {
+		return entry, nil
 	} else {
 		return nil, err
 	}
@@ -100,16 +198,26 @@ func (self *FilesystemBackend) path(relativePath string) string {
 	return path.Clean(path.Join(self.config.Path, relativePath))
 }
 
-func (self *FilesystemBackend) entryFromFileInfo(absPath string, info os.FileInfo) (*library.Entry, error) {
+func (self *FilesystemBackend) entryFromFileInfo(absPath string, info os.FileInfo, split *cueSplit) (*library.Entry, error) {
 	relativePath := strings.TrimPrefix(absPath, self.config.Path)
 
 	entry := &library.Entry{
 		Path:     relativePath,
-		Metadata: loadMetadata(absPath),
+		Metadata: self.loadMetadata(absPath),
 	}
 
 	if info.IsDir() {
 		entry.Type = library.FolderEntry
+	} else if split != nil {
+		// the tracks of files that are split up by a cue sheet are browsed like a folder
+		entry.Type = library.FolderEntry
+
+		if split.Modified.After(entry.Metadata.LastModified) {
+			entry.Metadata.LastModified = split.Modified
+		}
+	} else if library.IsPlaylistFile(info.Name()) {
+		// checked before the MIME type, since some playlist formats have audio/* types
+		entry.Type = library.PlaylistEntry
 	} else {
 		if mt, _ := stringutil.SplitPair(entry.MimeType(), `/`); mt != `application` {
 			switch mt {
@@ -130,13 +238,98 @@ func (self *FilesystemBackend) entryFromFileInfo(absPath string, info os.FileInf
 			log.Debugf("File open: %v", absPath)
 			return os.Open(absPath)
 		}))
+
+		entry.SetLocalPath(absPath)
 	}
 
 	return entry, nil
 }
 
-func loadMetadata(filename string) library.Metadata {
-	var meta library.Metadata
+// Returns the tracks listed in a playlist file.  Paths are resolved relative to the folder the
+// playlist is in (or to the root of the library, for absolute paths inside of it), and tracks
+// that aren't in the library are skipped.  URLs are returned as remote entries.
+func (self *FilesystemBackend) playlistEntries(relativePath string) (library.EntryList, error) {
+	absPath := self.path(relativePath)
+	file, err := os.Open(absPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	items, err := library.ParsePlaylist(absPath, file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(library.EntryList, 0, len(items))
+	root := path.Clean(self.config.Path)
+
+	for _, item := range items {
+		if item.IsRemote() {
+			entry := library.NewRemoteEntry(item.Location)
+			entry.Metadata.Title = item.Title
+			entry.Metadata.Duration = item.Duration
+
+			entries = append(entries, entry)
+			continue
+		}
+
+		var trackPath string
+
+		if path.IsAbs(item.Location) {
+			if item.Location != root && !strings.HasPrefix(item.Location, root+`/`) {
+				log.Warningf("Playlist %v: %v is not in the library", relativePath, item.Location)
+				continue
+			}
+
+			trackPath = strings.TrimPrefix(item.Location, root)
+		} else if trackPath = path.Join(path.Dir(strings.TrimPrefix(relativePath, `/`)), item.Location); trackPath == `..` || strings.HasPrefix(trackPath, `../`) {
+			log.Warningf("Playlist %v: %v is not in the library", relativePath, item.Location)
+			continue
+		}
+
+		if entry, err := self.Get(trackPath); err == nil && entry.IsContent() {
+			if entry.Metadata.Title == `` {
+				entry.Metadata.Title = item.Title
+			}
+
+			entries = append(entries, entry)
+		} else {
+			log.Warningf("Playlist %v: cannot load %v", relativePath, item.Location)
+		}
+	}
+
+	return entries, nil
+}
+
+// Measures the loudness of audio files without ReplayGain tags (if the library is configured to),
+// so that they can be normalized too.  This is too slow to do while browsing, so it is only done
+// when the database is updated; in the meantime, loadMetadata uses any measurements already taken.
+func (self *FilesystemBackend) Analyze(entry *library.Entry) error {
+	if !self.config.Loudness || !entry.IsContent() {
+		return nil
+	} else if entry.Metadata.ReplayGain != nil && entry.Metadata.ReplayGain.Track != nil {
+		return nil
+	}
+
+	filename, ok := entry.LocalPath()
+
+	if !ok || metadata.GetGeneralFileType(filename) != `audio` {
+		return nil
+	}
+
+	if data, err := metadata.LoudnessMetadata(filename, true); err == nil {
+		entry.Metadata.ReplayGain = metadataFromMap(data).ReplayGain
+		return nil
+	} else {
+		return fmt.Errorf("Cannot measure loudness of %v: %v", filename, err)
+	}
+}
+
+func (self *FilesystemBackend) loadMetadata(filename string) library.Metadata {
 	data := make(map[string]interface{})
 
 	for _, loader := range metadata.GetLoadersForFile(filename, LocalMetadataDetail) {
@@ -145,6 +338,19 @@ func loadMetadata(filename string) library.Metadata {
 		}
 	}
 
+	// files without ReplayGain tags get the values measured the last time they were analyzed
+	if self.config.Loudness && maputil.M(data).Get(`media.replaygain_track_gain`).IsNil() {
+		if d, err := metadata.LoudnessMetadata(filename, false); err == nil {
+			data, _ = maputil.Merge(data, d)
+		}
+	}
+
+	return metadataFromMap(data)
+}
+
+func metadataFromMap(data map[string]interface{}) library.Metadata {
+	var meta library.Metadata
+
 	meta.LastModified = maputil.M(data).Time(`file.modified_at`)
 
 	for key, value := range maputil.M(data).Map(`media`) {
@@ -164,8 +370,31 @@ func loadMetadata(filename string) library.Metadata {
 		case `genre`:
 			meta.Genre = value.String()
 		case `duration`:
+			// loaders report durations in milliseconds
 			if duration, ok := value.Value.(time.Duration); ok {
 				meta.Duration = duration
+			} else if ms := value.Int(); ms > 0 {
+				meta.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case `replaygain_track_gain`, `replaygain_track_peak`, `replaygain_album_gain`, `replaygain_album_peak`:
+			if meta.ReplayGain == nil {
+				meta.ReplayGain = new(library.ReplayGain)
+			}
+
+			tuple := &meta.ReplayGain.Track
+
+			if strings.HasPrefix(k, `replaygain_album_`) {
+				tuple = &meta.ReplayGain.Album
+			}
+
+			if *tuple == nil {
+				*tuple = new(library.ReplayGainTuple)
+			}
+
+			if strings.HasSuffix(k, `_gain`) {
+				(*tuple).Gain = value.Float()
+			} else {
+				(*tuple).Peak = value.Float()
 			}
 		default:
 			if meta.Extra == nil {
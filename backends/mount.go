@@ -0,0 +1,154 @@
+package backends
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/moped/library"
+)
+
+// A MountFactory creates a library for the storage at the given URI (as given to the "mount"
+// command).
+type MountFactory func(uri *url.URL) (library.Library, error)
+
+var mountFactories = make(map[string]MountFactory)
+var mountLock sync.RWMutex
+
+func init() {
+	RegisterScheme(`file`, mountFilesystem)
+}
+
+// Registers the factory that creates libraries for URIs with the given scheme.  It panics if the
+// factory is nil, or if a factory has already been registered for the scheme.
+func RegisterScheme(scheme string, factory MountFactory) {
+	mountLock.Lock()
+	defer mountLock.Unlock()
+
+	scheme = strings.ToLower(scheme)
+
+	if factory == nil {
+		panic(`backends: RegisterScheme factory is nil`)
+	} else if _, dup := mountFactories[scheme]; dup {
+		panic(`backends: RegisterScheme called twice for scheme ` + scheme)
+	}
+
+	mountFactories[scheme] = factory
+}
+
+// Returns the URI schemes that libraries can be mounted from, in sorted order.
+func MountSchemes() []string {
+	mountLock.RLock()
+	defer mountLock.RUnlock()
+
+	schemes := maputil.StringKeys(mountFactories)
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Creates a library for the storage at the given URI.  Absolute paths are taken to be local
+// folders.
+func Mount(uri string) (library.Library, error) {
+	if strings.HasPrefix(uri, `/`) {
+		uri = `file://` + uri
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URI %q: %v", uri, err)
+	}
+
+	mountLock.RLock()
+	factory, ok := mountFactories[strings.ToLower(u.Scheme)]
+	mountLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unrecognized storage URI %q (supported schemes: %v)", uri, strings.Join(MountSchemes(), `, `))
+	}
+
+	return factory(u)
+}
+
+func mountFilesystem(uri *url.URL) (library.Library, error) {
+	if uri.Host != `` && uri.Host != `localhost` {
+		return nil, fmt.Errorf("Cannot mount files on remote host %q", uri.Host)
+	}
+
+	dir := path.Clean(uri.Path)
+
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", dir)
+	}
+
+	return New(`local`, map[string]interface{}{
+		`path`: dir,
+	})
+}
+
+// Storage that could be mounted, as reported by the "listneighbors" command.
+type Neighbor struct {
+	URI  string
+	Name string
+}
+
+func (self *Neighbor) String() string {
+	return fmt.Sprintf("neighbor: %v\nname: %v\n", self.URI, self.Name)
+}
+
+// The folders that removable media (USB sticks, SD cards and the like) are usually mounted under.
+var NeighborFolders = []string{
+	`/media`,
+	`/run/media`,
+	`/mnt`,
+}
+
+// Returns the filesystems mounted beneath any of the NeighborFolders, which are likely to be
+// removable media.
+func Neighbors() ([]*Neighbor, error) {
+	neighbors := make([]*Neighbor, 0)
+	file, err := os.Open(`/proc/self/mounts`)
+
+	if os.IsNotExist(err) {
+		return neighbors, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		// spaces and the like are octal-escaped in the mount table
+		dir := strings.NewReplacer(`\040`, ` `, `\011`, "\t", `\134`, `\`).Replace(fields[1])
+
+		for _, parent := range NeighborFolders {
+			if strings.HasPrefix(dir, parent+`/`) {
+				neighbors = append(neighbors, &Neighbor{
+					URI:  (&url.URL{Scheme: `file`, Path: dir}).String(),
+					Name: path.Base(dir),
+				})
+
+				break
+			}
+		}
+	}
+
+	return neighbors, scanner.Err()
+}
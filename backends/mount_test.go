@@ -0,0 +1,45 @@
+package backends
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ghetzel/moped/library"
+)
+
+// returns whether calling fn panics
+func panics(fn func()) (panicked bool) {
+	defer func() {
+		panicked = (recover() != nil)
+	}()
+
+	fn()
+	return
+}
+
+func TestRegisterScheme(t *testing.T) {
+	if got := MountSchemes(); !reflect.DeepEqual(got, []string{`file`}) {
+		t.Errorf("MountSchemes() = %q, want %q", got, []string{`file`})
+	}
+
+	noop := func(uri *url.URL) (library.Library, error) {
+		return nil, nil
+	}
+
+	if !panics(func() { RegisterScheme(`test`, nil) }) {
+		t.Errorf("registering a nil factory didn't panic")
+	}
+
+	if !panics(func() { RegisterScheme(`FILE`, noop) }) {
+		t.Errorf("registering a scheme twice didn't panic")
+	}
+
+	if !panics(func() { Register(`test`, nil) }) {
+		t.Errorf("registering a nil library factory didn't panic")
+	}
+
+	if !panics(func() { Register(`local`, func(map[string]interface{}) (library.Library, error) { return nil, nil }) }) {
+		t.Errorf("registering a library type twice didn't panic")
+	}
+}
@@ -0,0 +1,211 @@
+package backends
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// How long the filesystem has to be quiet before changes are reported, so that copying an album
+// into the library results in one update rather than one per file.
+var WatchDebounce = 2 * time.Second
+
+// Starts watching the library for changes, if the watch setting is on.  Changed folders are
+// reported (relative to the library) once things have settled down.
+func (self *FilesystemBackend) Watch(fn func(folders []string)) error {
+	if !self.config.Watch {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	fw := &filesystemWatcher{
+		backend: self,
+		watcher: watcher,
+		notify:  fn,
+		changed: make(map[string]bool),
+	}
+
+	if err := fw.add(self.config.Path); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	self.watchLock.Lock()
+	defer self.watchLock.Unlock()
+
+	if self.watcher != nil {
+		self.watcher.close()
+	}
+
+	self.watcher = fw
+	go fw.run()
+
+	return nil
+}
+
+// Stops watching the library for changes.  Changes that haven't been reported yet are dropped.
+func (self *FilesystemBackend) Close() error {
+	self.watchLock.Lock()
+	defer self.watchLock.Unlock()
+
+	if self.watcher != nil {
+		err := self.watcher.close()
+		self.watcher = nil
+		return err
+	}
+
+	return nil
+}
+
+type filesystemWatcher struct {
+	backend *FilesystemBackend
+	watcher *fsnotify.Watcher
+	notify  func([]string)
+	changed map[string]bool
+	timer   *time.Timer
+	closed  bool
+	lock    sync.Mutex
+}
+
+// stops the watcher, which also ends its goroutine once the event channels are closed
+func (self *filesystemWatcher) close() error {
+	self.lock.Lock()
+	self.closed = true
+
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+
+	self.lock.Unlock()
+
+	return self.watcher.Close()
+}
+
+// watches the given directory and everything below it (since inotify watches aren't recursive)
+func (self *filesystemWatcher) add(dir string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if name != dir && strings.HasPrefix(info.Name(), `.`) {
+				return filepath.SkipDir
+			}
+
+			if err := self.watcher.Add(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (self *filesystemWatcher) run() {
+	for {
+		select {
+		case event, ok := <-self.watcher.Events:
+			if !ok {
+				return
+			}
+
+			self.handle(event)
+
+		case err, ok := <-self.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Warningf("Watching %v: %v", self.backend.config.Path, err)
+		}
+	}
+}
+
+func (self *filesystemWatcher) handle(event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod || strings.HasPrefix(filepath.Base(event.Name), `.`) {
+		return
+	}
+
+	// new directories need watching too; anything created in them before the watch was added is
+	// picked up when the folder is scanned
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := self.add(event.Name); err != nil {
+				log.Warningf("Cannot watch %v: %v", event.Name, err)
+			}
+		}
+	}
+
+	folder := strings.TrimPrefix(filepath.Dir(event.Name), self.backend.config.Path)
+	folder = strings.Trim(filepath.ToSlash(folder), `/`)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return
+	}
+
+	self.changed[folder] = true
+
+	if self.timer == nil {
+		self.timer = time.AfterFunc(WatchDebounce, self.flush)
+	} else {
+		self.timer.Reset(WatchDebounce)
+	}
+}
+
+func (self *filesystemWatcher) flush() {
+	self.lock.Lock()
+
+	if self.closed {
+		self.lock.Unlock()
+		return
+	}
+
+	folders := make([]string, 0, len(self.changed))
+
+	for folder := range self.changed {
+		folders = append(folders, folder)
+	}
+
+	self.changed = make(map[string]bool)
+	self.timer = nil
+	self.lock.Unlock()
+
+	if len(folders) > 0 {
+		self.notify(outermostFolders(folders))
+	}
+}
+
+// Removes folders that are inside of other folders in the list, since updating a folder also
+// updates everything in it.
+func outermostFolders(folders []string) []string {
+	sort.Strings(folders)
+	outermost := make([]string, 0, len(folders))
+
+	for _, folder := range folders {
+		if n := len(outermost); n > 0 {
+			if last := outermost[n-1]; last == `` || folder == last || strings.HasPrefix(folder, last+`/`) {
+				continue
+			}
+		}
+
+		outermost = append(outermost, folder)
+	}
+
+	return outermost
+}
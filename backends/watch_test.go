@@ -0,0 +1,80 @@
+package backends
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilesystemWatch(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `moped-watch`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, `album`), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(debounce time.Duration) {
+		WatchDebounce = debounce
+	}(WatchDebounce)
+
+	WatchDebounce = 50 * time.Millisecond
+
+	// the redundant slashes shouldn't end up in the folders that are reported
+	backend, err := NewFilesystemBackend(&FilesystemConfig{
+		Path:  dir + `//`,
+		Watch: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan []string, 8)
+
+	if err := backend.Watch(func(folders []string) {
+		changes <- folders
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, `album`, `one.flac`), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case folders := <-changes:
+		if want := []string{`album`}; !reflect.DeepEqual(folders, want) {
+			t.Errorf("changed folders = %q, want %q", folders, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change was not reported")
+	}
+
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is reported once the library is closed
+	if err := ioutil.WriteFile(filepath.Join(dir, `album`, `two.flac`), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case folders := <-changes:
+		t.Errorf("changed folders %q were reported after closing", folders)
+	case <-time.After(4 * WatchDebounce):
+	}
+
+	if err := backend.Close(); err != nil {
+		t.Errorf("closing again failed: %v", err)
+	}
+}
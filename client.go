@@ -2,6 +2,7 @@ package moped
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"net"
 	"regexp"
@@ -9,11 +10,27 @@ import (
 	"sync"
 
 	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
 	"github.com/ghetzel/go-stockutil/stringutil"
 	"github.com/kballard/go-shellquote"
 )
 
 var rxWhitespace = regexp.MustCompile(`\s+`)
+var rxChannelName = regexp.MustCompile(`^[A-Za-z0-9_\-.:]+$`)
+
+// how many channels a client can be subscribed to, and how many unread messages it can have
+var MaxSubscriptions = 16
+var MaxMessages = 64
+
+// A message sent to a channel with the "sendmessage" command.
+type ClientMessage struct {
+	Channel string
+	Message string
+}
+
+func (self *ClientMessage) String() string {
+	return fmt.Sprintf("channel: %v\nmessage: %v\n", self.Channel, self.Message)
+}
 
 type Client struct {
 	id                string
@@ -24,6 +41,11 @@ type Client struct {
 	running           bool
 	cmdchan           chan cmdset
 	replychan         chan *reply
+	channels          map[string]bool
+	messages          []*ClientMessage
+	msglock           sync.Mutex
+	partition         *Partition
+	partitionLock     sync.RWMutex
 }
 
 func NewClient(app *Moped, conn net.Conn) *Client {
@@ -34,6 +56,9 @@ func NewClient(app *Moped, conn net.Conn) *Client {
 		running:   true,
 		cmdchan:   make(chan cmdset),
 		replychan: make(chan *reply),
+		channels:  make(map[string]bool),
+		messages:  make([]*ClientMessage, 0),
+		partition: app.DefaultPartition(),
 	}
 }
 
@@ -57,6 +82,114 @@ func (self *Client) RetrieveAndClearSubsystems() []string {
 	return changes
 }
 
+// Subscribes the client to the given channel, so that it receives the messages sent to it.
+func (self *Client) Subscribe(channel string) error {
+	if !rxChannelName.MatchString(channel) {
+		return fmt.Errorf("Invalid channel name %q", channel)
+	}
+
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	if self.channels[channel] {
+		return fmt.Errorf("Already subscribed to %q", channel)
+	} else if len(self.channels) >= MaxSubscriptions {
+		return fmt.Errorf("Too many subscriptions")
+	}
+
+	self.channels[channel] = true
+	return nil
+}
+
+func (self *Client) Unsubscribe(channel string) error {
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	if !self.channels[channel] {
+		return fmt.Errorf("Not subscribed to %q", channel)
+	}
+
+	delete(self.channels, channel)
+	return nil
+}
+
+// Returns whether the client is subscribed to any of the given channels.
+func (self *Client) IsSubscribed(channels ...string) bool {
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	for _, channel := range channels {
+		if self.channels[channel] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the channels the client is subscribed to.
+func (self *Client) Channels() []string {
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	return maputil.StringKeys(self.channels)
+}
+
+// Adds a message to the client's queue, if it is subscribed to the channel it was sent to.
+// Returns whether the client is subscribed, and whether the message was queued (which it isn't if
+// the client already has as many unread messages as it can hold).
+func (self *Client) PushMessage(message *ClientMessage) (bool, bool) {
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	if !self.channels[message.Channel] {
+		return false, false
+	} else if len(self.messages) >= MaxMessages {
+		return true, false
+	}
+
+	self.messages = append(self.messages, message)
+	return true, true
+}
+
+// Returns (and removes) all of the messages in the client's queue.
+func (self *Client) ReadMessages() []*ClientMessage {
+	self.msglock.Lock()
+	defer self.msglock.Unlock()
+
+	messages := self.messages
+	self.messages = make([]*ClientMessage, 0)
+
+	return messages
+}
+
+// Returns the partition that the client's playback commands apply to.
+func (self *Client) Partition() *Partition {
+	self.partitionLock.RLock()
+	defer self.partitionLock.RUnlock()
+
+	return self.partition
+}
+
+// Moves the client to the named partition.  This holds the application's partition lock, so that
+// the partition can't be deleted while the client is moving into it.
+func (self *Client) SetPartition(name string) error {
+	self.app.partitionLock.RLock()
+	defer self.app.partitionLock.RUnlock()
+
+	partition, ok := self.app.partitions[name]
+
+	if !ok {
+		return fmt.Errorf("No such partition")
+	}
+
+	self.partitionLock.Lock()
+	self.partition = partition
+	self.partitionLock.Unlock()
+
+	return nil
+}
+
 func (self *Client) ID() string {
 	return self.id
 }
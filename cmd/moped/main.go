@@ -57,6 +57,53 @@ func main() {
 			} else {
 				return err
 			}
+
+			if err := application.SetDatabase(config.Database); err != nil {
+				return err
+			}
+
+			if err := application.SetPlaylistDirectory(config.PlaylistDirectory); err != nil {
+				return err
+			}
+
+			if devices, err := moped.GetOutputsFromConfig(config); err == nil {
+				for _, device := range devices {
+					if err := application.AddOutput(device); err != nil {
+						return err
+					}
+				}
+			} else {
+				return err
+			}
+
+			if format, err := config.GetAudioFormat(); err == nil {
+				application.AudioFormat = format
+			} else {
+				return err
+			}
+
+			if order, err := config.GetRandomOrder(); err == nil {
+				application.DefaultPartition().UpdatePlaymode(func(mode *moped.Playmode) error {
+					mode.RandomOrder = order
+					return nil
+				})
+			} else {
+				return err
+			}
+
+			if settings, rgmode, err := config.GetReplayGain(); err == nil {
+				application.ReplayGain = settings
+				application.DefaultPartition().UpdatePlaymode(func(mode *moped.Playmode) error {
+					mode.ReplayGainMode = rgmode
+					return nil
+				})
+			} else {
+				return err
+			}
+
+			if err := application.SetStateFile(config.StateFile); err != nil {
+				return err
+			}
 		} else {
 			return err
 		}
@@ -1,16 +1,134 @@
 package moped
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
 
-func (self *Moped) cmdAudio(c *cmd) *reply {
-	switch c.Command {
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/moped/outputs"
+)
+
+type outputEntry struct {
+	*outputs.Device
+}
+
+func (self *outputEntry) String() string {
+	out := fmt.Sprintf("outputid: %d\n", self.ID)
+	out += fmt.Sprintf("outputname: %v\n", self.Name)
+	out += fmt.Sprintf("plugin: %v\n", self.Type)
+	out += fmt.Sprintf("outputenabled: %d\n", b2i(self.IsEnabled()))
+
+	attributes := self.Attributes()
+	keys := maputil.StringKeys(attributes)
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		out += fmt.Sprintf("attribute: %v=%v\n", key, attributes[key])
+	}
+
+	return out
+}
+
+func (self *Partition) cmdAudio(c *cmd) *reply {
+	switch command := c.Command; command {
 	case `outputs`:
+		results := make([]*outputEntry, 0)
+
+		for _, device := range self.outputs.List() {
+			results = append(results, &outputEntry{
+				Device: device,
+			})
+		}
+
+		return NewReply(c, results)
+
+	case `enableoutput`, `disableoutput`, `toggleoutput`, `outputset`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `ID`))
+		}
+
+		var device *outputs.Device
+
+		if id, err := strconv.Atoi(c.Arg(0).String()); err == nil {
+			if d, ok := self.outputs.Get(id); ok {
+				device = d
+			}
+		}
+
+		if device == nil {
+			return NewReply(c, fmt.Errorf("No such audio output"))
+		}
+
+		var err error
+		var changed bool
+
+		switch command {
+		case `enableoutput`, `disableoutput`, `toggleoutput`:
+			was := device.IsEnabled()
+			enabled := (command == `enableoutput`) || (command == `toggleoutput` && !was)
+
+			if enabled != was {
+				err = device.SetEnabled(enabled)
+				changed = true
+			}
+		case `outputset`:
+			if len(c.Arguments) < 3 {
+				return NewReply(c, fmt.Errorf("Must specify %q, %q and %q", `ID`, `NAME`, `VALUE`))
+			}
+
+			name, value := c.Arg(1).String(), c.Arg(2).String()
+
+			if current, ok := device.Attributes()[name]; !ok || current != value {
+				err = device.SetAttribute(name, value)
+				changed = true
+			}
+		}
+
+		if err != nil {
+			return NewReply(c, err)
+		}
+
+		// only actual changes are worth telling anyone about (or saving)
+		if changed {
+			self.AddChangedSubsystem(`output`)
+			self.saveStateOrWarn()
+		}
+
+		return NewReply(c, nil)
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+}
+
+func (self *Partition) cmdVolume(c *cmd) *reply {
+	switch c.Command {
+	case `getvol`:
 		return NewReply(c, map[string]interface{}{
-			`outputid`:      0,
-			`outputname`:    `PulseAudio`,
-			`outputenabled`: 1,
+			`volume`: self.mixer.Volume(),
 		})
 
+	case `setvol`, `volume`:
+		arg := c.Arg(0)
+
+		if arg.IsNil() {
+			return NewReply(c, fmt.Errorf("Must specify %q", `VOL`))
+		}
+
+		value, err := stringutil.ConvertToInteger(arg.String())
+
+		if err != nil {
+			return NewReply(c, fmt.Errorf("Invalid volume value %q", arg.String()))
+		}
+
+		if c.Command == `volume` {
+			return NewReply(c, self.mixer.AdjustVolume(int(value)))
+		} else {
+			return NewReply(c, self.mixer.SetVolume(int(value)))
+		}
+
 	default:
 		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
 	}
@@ -2,8 +2,11 @@ package moped
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/ghetzel/go-stockutil/stringutil"
 	"github.com/ghetzel/moped/library"
 )
 
@@ -30,12 +33,21 @@ func (self *dbEntry) String() string {
 	out += self.stringEmIfYouGotEm(`Artist`)
 	out += self.stringEmIfYouGotEm(`Album`)
 
+	if d := self.Duration(); d > 0 && self.IsContent() {
+		out += fmt.Sprintf("Time: %d\n", int(d.Round(time.Second)/time.Second))
+		out += fmt.Sprintf("duration: %s\n", formatSeconds(d))
+	}
+
 	return out
 }
 
 func (self *dbEntry) stringEmIfYouGotEm(tag string) string {
 	if v := self.Get(tag); !v.IsNil() {
 		if tm, ok := v.Value.(time.Time); ok {
+			if tm.IsZero() {
+				return ``
+			}
+
 			return fmt.Sprintf("%v: %v\n", tag, tm.Format(time.RFC3339))
 		} else if vS := v.String(); vS != `` {
 			return fmt.Sprintf("%v: %v\n", tag, v)
@@ -77,19 +89,279 @@ func (self *Moped) entries(c *cmd, exprkey string, values ...string) *reply {
 	}
 }
 
-func (self *Moped) cmdDbBrowse(c *cmd) *reply {
+// The parts of a search command (find, search, count, ...): which entries to match, how to sort
+// them, which part of the results to return, and which tags to group them by.
+type searchQuery struct {
+	Filter     library.Filter
+	Sort       string
+	Descending bool
+	Start      int
+	End        int
+	Groups     []string
+}
+
+// Parses the arguments of a search command.  The filter comes first, followed by any of "sort
+// TAG" (or "sort -TAG" for descending order), "window START:END" and "group TAG".
+func parseSearchQuery(args []string, foldCase bool) (*searchQuery, error) {
+	query := &searchQuery{
+		End: -1,
+	}
+
+	for len(args) >= 2 {
+		keyword, value := args[len(args)-2], args[len(args)-1]
+
+		switch keyword {
+		case `sort`:
+			query.Sort = strings.TrimPrefix(value, `-`)
+			query.Descending = strings.HasPrefix(value, `-`)
+		case `window`:
+			if start, end, err := getRangeFromArg(value); err == nil {
+				query.Start = start
+				query.End = end
+			} else {
+				return nil, err
+			}
+		case `group`:
+			query.Groups = append([]string{value}, query.Groups...)
+		default:
+			keyword = ``
+		}
+
+		if keyword == `` {
+			break
+		}
+
+		args = args[:len(args)-2]
+	}
+
+	if filter, err := library.ParseFilter(args, foldCase); err == nil {
+		query.Filter = filter
+	} else {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Sorts and windows the given search results as per the query.
+func (self *searchQuery) Apply(entries library.EntryList) library.EntryList {
+	if self.Sort != `` {
+		sort.SliceStable(entries, func(i int, j int) bool {
+			a := entries[i].Tag(self.Sort)
+			b := entries[j].Tag(self.Sort)
+
+			if self.Descending {
+				a, b = b, a
+			}
+
+			// numeric tags (like Track) are compared as numbers
+			if aN, err := stringutil.ConvertToInteger(a); err == nil {
+				if bN, err := stringutil.ConvertToInteger(b); err == nil {
+					return aN < bN
+				}
+			}
+
+			return a < b
+		})
+	}
+
+	start := self.Start
+	end := self.End
+
+	if end < 0 || end > len(entries) {
+		end = len(entries)
+	}
+
+	if start > end {
+		start = end
+	}
+
+	return entries[start:end]
+}
+
+// one group of the results of a grouped count
+type countGroup struct {
+	Tags     []string
+	Values   []string
+	Songs    int
+	Playtime time.Duration
+}
+
+func (self *countGroup) String() string {
+	var out string
+
+	for i, tag := range self.Tags {
+		out += fmt.Sprintf("%v: %v\n", library.TagName(tag), self.Values[i])
+	}
+
+	out += fmt.Sprintf("songs: %d\n", self.Songs)
+	out += fmt.Sprintf("playtime: %d\n", int(self.Playtime.Round(time.Second)/time.Second))
+
+	return out
+}
+
+func (self *Moped) cmdSearch(c *cmd) *reply {
+	if len(c.Arguments) == 0 && c.Command != `count` {
+		return NewReply(c, fmt.Errorf("Must specify a filter"))
+	}
+
+	query, err := parseSearchQuery(c.Arguments, (c.Command == `search`))
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	entries, err := self.Search(query.Filter)
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
 	switch c.Command {
-	case `lsinfo`:
-		return self.entries(c, `base`, c.Arg(0).String())
+	case `count`:
+		groups := make([]*countGroup, 0)
+		byKey := make(map[string]*countGroup)
 
-	case `list`:
-		return NewReply(c, nil)
+		for _, entry := range entries {
+			values := make([]string, len(query.Groups))
 
-	case `listplaylistinfo`:
-		return NewReply(c, nil)
+			for i, tag := range query.Groups {
+				values[i] = entry.Tag(tag)
+			}
+
+			key := strings.Join(values, "\x00")
+			group, ok := byKey[key]
 
-	case `find`:
-		return self.entries(c, c.Arguments[0], c.Arguments[1:]...)
+			if !ok {
+				group = &countGroup{
+					Tags:   query.Groups,
+					Values: values,
+				}
+
+				byKey[key] = group
+				groups = append(groups, group)
+			}
+
+			group.Songs++
+			group.Playtime += entry.Duration()
+		}
+
+		if len(groups) == 0 {
+			groups = append(groups, new(countGroup))
+		}
+
+		return NewReply(c, groups)
+
+	default:
+		results := make([]*dbEntry, 0)
+
+		for _, entry := range query.Apply(entries) {
+			results = append(results, &dbEntry{
+				Entry: entry,
+			})
+		}
+
+		return NewReply(c, results)
+	}
+}
+
+// Lists the unique values of a tag across all of the songs matching an (optional) filter.  When
+// grouped by other tags, the values are listed under the values of the groups they belong to.
+func (self *Moped) cmdList(c *cmd) *reply {
+	if len(c.Arguments) == 0 {
+		return NewReply(c, fmt.Errorf("Must specify a tag"))
+	}
+
+	tag := c.Arguments[0]
+	args := c.Arguments[1:]
+
+	// the legacy form of listing an artist's albums ("list album ARTIST")
+	if strings.EqualFold(tag, `album`) && len(args) == 1 && !strings.HasPrefix(args[0], `(`) {
+		args = []string{`Artist`, args[0]}
+	}
+
+	query, err := parseSearchQuery(args, false)
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	entries, err := self.Search(query.Filter)
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	tags := append(append([]string{}, query.Groups...), tag)
+	rows := make([][]string, 0)
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		row := make([]string, len(tags))
+
+		for i, t := range tags {
+			row[i] = entry.Tag(t)
+		}
+
+		if row[len(row)-1] == `` {
+			continue
+		}
+
+		if key := strings.Join(row, "\x00"); !seen[key] {
+			seen[key] = true
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i int, j int) bool {
+		for k := range rows[i] {
+			if rows[i][k] != rows[j][k] {
+				return rows[i][k] < rows[j][k]
+			}
+		}
+
+		return false
+	})
+
+	lines := make([]string, 0)
+	var previous []string
+
+	for _, row := range rows {
+		changed := (previous == nil)
+
+		// group values are only repeated when they (or one of the groups above them) change
+		for i, value := range row {
+			if !changed && previous[i] != value {
+				changed = true
+			}
+
+			if changed {
+				lines = append(lines, fmt.Sprintf("%v: %v", library.TagName(tags[i]), value))
+			}
+		}
+
+		previous = row
+	}
+
+	return NewReply(c, lines)
+}
+
+// Starts updating (or, with rescan, re-reading) the given path in the database, or all libraries
+// if no path is given.
+func (self *Moped) cmdUpdate(c *cmd) *reply {
+	if id, err := self.updater.Start((c.Command == `rescan`), c.Arg(0).String()); err == nil {
+		return NewReply(c, map[string]interface{}{
+			`updating_db`: id,
+		})
+	} else {
+		return NewReply(c, err)
+	}
+}
+
+func (self *Moped) cmdDbBrowse(c *cmd) *reply {
+	switch c.Command {
+	case `lsinfo`:
+		return self.entries(c, `base`, c.Arg(0).String())
 
 	default:
 		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
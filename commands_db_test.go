@@ -0,0 +1,61 @@
+package moped
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghetzel/moped/library"
+)
+
+func TestSearchQueryWindow(t *testing.T) {
+	entries := library.EntryList{
+		{Path: `a.flac`, Metadata: library.Metadata{Title: `a`}},
+		{Path: `b.flac`, Metadata: library.Metadata{Title: `b`}},
+		{Path: `c.flac`, Metadata: library.Metadata{Title: `c`}},
+	}
+
+	tests := []struct {
+		name   string
+		window string
+		want   []string
+		err    bool
+	}{
+		{name: `all`, window: `0:`, want: []string{`a`, `b`, `c`}},
+		{name: `range`, window: `1:2`, want: []string{`b`}},
+		{name: `single`, window: `2`, want: []string{`c`}},
+		{name: `past the end`, window: `1:99`, want: []string{`b`, `c`}},
+		{name: `starting past the end`, window: `5:9`, want: []string{}},
+		{name: `empty`, window: `1:1`, want: []string{}},
+		{name: `negative start`, window: `-5:2`, err: true},
+		{name: `negative end`, window: `0:-2`, err: true},
+		{name: `negative single`, window: `-1`, err: true},
+		{name: `inverted`, window: `2:1`, err: true},
+		{name: `garbage`, window: `x:y`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parseSearchQuery([]string{`title`, `x`, `window`, tt.window}, false)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("window %q was accepted as %d:%d", tt.window, query.Start, query.End)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("window %q was rejected: %v", tt.window, err)
+			}
+
+			got := make([]string, 0)
+
+			for _, entry := range query.Apply(append(library.EntryList{}, entries...)) {
+				got = append(got, entry.Metadata.Title)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("window %q gave %q, want %q", tt.window, got, tt.want)
+			}
+		})
+	}
+}
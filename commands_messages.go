@@ -0,0 +1,54 @@
+package moped
+
+import (
+	"fmt"
+)
+
+func (self *Moped) cmdMessaging(c *cmd) *reply {
+	client := c.Client
+
+	if client == nil {
+		return NewReply(c, fmt.Errorf("client unavailable"))
+	}
+
+	switch c.Command {
+	case `subscribe`, `unsubscribe`:
+		var err error
+
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+		} else if c.Command == `subscribe` {
+			err = client.Subscribe(c.Arg(0).String())
+		} else {
+			err = client.Unsubscribe(c.Arg(0).String())
+		}
+
+		if err == nil {
+			self.AddChangedSubsystem(`subscription`)
+		}
+
+		return NewReply(c, err)
+
+	case `channels`:
+		lines := make([]string, 0)
+
+		for _, channel := range self.Channels() {
+			lines = append(lines, fmt.Sprintf("channel: %v", channel))
+		}
+
+		return NewReply(c, lines)
+
+	case `readmessages`:
+		return NewReply(c, client.ReadMessages())
+
+	case `sendmessage`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `CHANNEL`, `TEXT`))
+		}
+
+		return NewReply(c, self.SendMessage(c.Arg(0).String(), c.Arg(1).String()))
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+}
@@ -0,0 +1,75 @@
+package moped
+
+import (
+	"fmt"
+
+	"github.com/ghetzel/moped/backends"
+	"github.com/ghetzel/moped/library"
+)
+
+// A library, and where it is kept, as reported by the "listmounts" command.
+type mountEntry struct {
+	Name    string
+	Storage string
+}
+
+func (self *mountEntry) String() string {
+	out := fmt.Sprintf("mount: %v\n", self.Name)
+
+	if self.Storage != `` {
+		out += fmt.Sprintf("storage: %v\n", self.Storage)
+	}
+
+	return out
+}
+
+func (self *Moped) cmdMounts(c *cmd) *reply {
+	switch c.Command {
+	case `mount`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `PATH`, `URI`))
+		}
+
+		return NewReply(c, self.Mount(c.Arg(0).String(), c.Arg(1).String()))
+
+	case `unmount`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `PATH`))
+		}
+
+		return NewReply(c, self.Unmount(c.Arg(0).String()))
+
+	case `listmounts`:
+		mounts := self.Mounts()
+		results := make([]*mountEntry, 0)
+
+		for _, name := range self.LibraryNames() {
+			entry := &mountEntry{
+				Name:    name,
+				Storage: mounts[name],
+			}
+
+			if entry.Storage == `` {
+				if lib, ok := self.Library(name); ok {
+					if locator, ok := lib.(library.Locator); ok {
+						entry.Storage = locator.URI()
+					}
+				}
+			}
+
+			results = append(results, entry)
+		}
+
+		return NewReply(c, results)
+
+	case `listneighbors`:
+		if neighbors, err := backends.Neighbors(); err == nil {
+			return NewReply(c, neighbors)
+		} else {
+			return NewReply(c, err)
+		}
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+}
@@ -0,0 +1,52 @@
+package moped
+
+import (
+	"fmt"
+)
+
+func (self *Moped) cmdPartitions(c *cmd) *reply {
+	switch c.Command {
+	case `partition`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+		} else if c.Client == nil {
+			return NewReply(c, fmt.Errorf("client unavailable"))
+		}
+
+		return NewReply(c, c.Client.SetPartition(c.Arg(0).String()))
+
+	case `listpartitions`:
+		lines := make([]string, 0)
+
+		for _, partition := range self.Partitions() {
+			lines = append(lines, fmt.Sprintf("partition: %v", partition.Name))
+		}
+
+		return NewReply(c, lines)
+
+	case `newpartition`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+		}
+
+		_, err := self.CreatePartition(c.Arg(0).String())
+		return NewReply(c, err)
+
+	case `delpartition`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+		}
+
+		return NewReply(c, self.DeletePartition(c.Arg(0).String()))
+
+	case `moveoutput`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `OUTPUTNAME`))
+		}
+
+		return NewReply(c, self.MoveOutput(c.Arg(0).String(), self.clientPartition(c.Client)))
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+}
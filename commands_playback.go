@@ -1,103 +1,227 @@
 package moped
 
-func (self *Moped) cmdToggles(c *cmd) *reply {
-	return NotImplemented(c)
-	// if len(c.Arguments) == 0 {
-	// 	state := typeutil.V(c.Arguments[0]).Bool()
-
-	// 	switch c.Command {
-	// 	case `consume`:
-	// 		self.playmode.Consume = state
-	// 	case `random`:
-	// 		self.playmode.Random = state
-	// 	case `repeat`:
-	// 		self.playmode.Repeat = state
-	// 	case `single`:
-	// 		self.playmode.Single = state
-	// 	case `crossfade`:
-	// 		self.playmode.Crossfade = int(typeutil.V(c.Arguments[0]).Int())
-	// 	default:
-	// 		return NewReply(c, fmt.Errorf("Unsupported state command %q", c.Command))
-	// 	}
-
-	// 	return NewReply(c, nil)
-	// } else {
-	// 	return NewReply(c, fmt.Errorf("wrong number of arguments for %q", c.Command))
-	// }
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+)
+
+func (self *Partition) cmdToggles(c *cmd) *reply {
+	if len(c.Arguments) != 1 {
+		return NewReply(c, fmt.Errorf("wrong number of arguments for %q", c.Command))
+	}
+
+	arg := c.Arg(0)
+
+	return NewReply(c, self.UpdatePlaymode(func(mode *Playmode) error {
+		switch c.Command {
+		case `consume`:
+			if state, err := getBoolFromArg(arg.String()); err == nil {
+				mode.Consume = state
+			} else {
+				return err
+			}
+		case `random`:
+			// besides 0 and 1, random accepts the name of a random EntryOrder to switch the
+			// shuffling strategy (e.g.: "random random-by-album")
+			if order := library.EntryOrder(arg.String()); IsRandomOrder(order) {
+				mode.Random = true
+				mode.RandomOrder = order
+			} else if state, err := getBoolFromArg(arg.String()); err == nil {
+				mode.Random = state
+			} else {
+				return err
+			}
+		case `repeat`:
+			if state, err := getBoolFromArg(arg.String()); err == nil {
+				mode.Repeat = state
+			} else {
+				return err
+			}
+		case `single`:
+			if single, err := ParseSingleMode(arg.String()); err == nil {
+				mode.Single = single
+			} else {
+				return err
+			}
+		case `crossfade`:
+			if seconds, err := strconv.Atoi(arg.String()); err == nil && seconds >= 0 {
+				mode.Crossfade = seconds
+			} else {
+				return fmt.Errorf("Invalid crossfade value")
+			}
+		case `mixrampdb`:
+			if db, err := stringutil.ConvertToFloat(arg.String()); err == nil && db <= 0 {
+				mode.MixRampDB = db
+			} else {
+				return fmt.Errorf("Invalid mixrampdb value")
+			}
+		case `mixrampdelay`:
+			// MPD uses "nan" to switch MixRamp off
+			if strings.EqualFold(arg.String(), `nan`) {
+				mode.MixRamp = false
+				mode.MixRampDelay = 0
+			} else if delay, err := stringutil.ConvertToFloat(arg.String()); err == nil && delay >= 0 {
+				mode.MixRamp = true
+				mode.MixRampDelay = delay
+			} else {
+				return fmt.Errorf("Invalid mixrampdelay value")
+			}
+		default:
+			return fmt.Errorf("Unsupported state command %q", c.Command)
+		}
+
+		return nil
+	}))
 }
 
-func (self *Moped) cmdPlayControl(c *cmd) *reply {
-	return NotImplemented(c)
+func (self *Partition) cmdReplayGain(c *cmd) *reply {
+	switch c.Command {
+	case `replay_gain_mode`:
+		if len(c.Arguments) != 1 {
+			return NewReply(c, fmt.Errorf("wrong number of arguments for %q", c.Command))
+		}
 
-	// var err error
+		mode, err := audio.ParseReplayGainMode(c.Arg(0).String())
 
-	// arg := c.Arg(0)
+		if err != nil {
+			return NewReply(c, err)
+		}
 
-	// self.autoAdvance = (c.Command != `stop`)
+		return NewReply(c, self.UpdatePlaymode(func(playmode *Playmode) error {
+			playmode.ReplayGainMode = mode
+			return nil
+		}))
 
-	// switch command := c.Command; command {
-	// case `next`:
-	// 	err = self.queue.Next()
+	case `replay_gain_status`:
+		mode := self.Playmode().ReplayGainMode
 
-	// case `previous`:
-	// 	err = self.queue.Previous()
+		if mode == `` {
+			mode = audio.ReplayGainOff
+		}
 
-	// case `pause`:
-	// 	if arg.IsNil() || arg.Bool() {
-	// 		err = self.Pause()
-	// 	} else {
-	// 		err = self.Resume()
-	// 	}
-	// case `play`, `playid`:
-	// 	if !arg.IsNil() {
-	// 		switch command {
-	// 		case `playid`:
-	// 			err = self.queue.JumpID(library.EntryID(arg.Int()))
-	// 		default:
-	// 			err = self.queue.Jump(int(arg.Int()))
-	// 		}
+		return NewReply(c, map[string]interface{}{
+			`replay_gain_mode`: mode,
+		})
 
-	// 		if err != nil {
-	// 			return NewReply(c, err)
-	// 		}
-	// 	}
-
-	// 	err = self.queue.Play()
-
-	// case `stop`:
-	// 	err = self.Stop()
-
-	// case `seek`, `seekid`:
-	// 	if len(c.Arguments) < 2 {
-	// 		return NewReply(c, fmt.Errorf("Must specify %q and %q", `POS`, `TIME`))
-	// 	}
-
-	// 	if !arg.IsNil() {
-	// 		switch command {
-	// 		case `seekid`:
-	// 			err = self.queue.JumpID(library.EntryID(arg.Int()))
-	// 		default:
-	// 			err = self.queue.Jump(int(arg.Int()))
-	// 		}
-
-	// 		if err != nil {
-	// 			return NewReply(c, err)
-	// 		}
-	// 	}
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+}
 
-	// 	fallthrough
+func (self *Partition) cmdPlayControl(c *cmd) *reply {
+	var err error
+
+	arg := c.Arg(0)
+
+	switch command := c.Command; command {
+	case `next`:
+		err = self.player.Next()
+
+	case `previous`:
+		err = self.player.Previous()
+
+	case `pause`:
+		if arg.IsNil() {
+			err = self.player.TogglePause()
+		} else if state, perr := getBoolFromArg(arg.String()); perr == nil {
+			err = self.player.Pause(state)
+		} else {
+			err = perr
+		}
+
+	case `play`:
+		if arg.IsNil() {
+			err = self.player.Play()
+		} else if pos, perr := getSongPosFromArg(arg.String()); perr == nil {
+			err = self.player.PlayPosition(pos)
+		} else {
+			err = perr
+		}
+
+	case `playid`:
+		if arg.IsNil() {
+			err = self.player.Play()
+		} else if id, rerr := getSongIdFromCmd(c, 0); rerr == nil {
+			err = self.player.PlayID(id)
+		} else {
+			err = rerr
+		}
+
+	case `stop`:
+		err = self.player.Stop()
+
+	case `seek`, `seekid`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `POS`, `TIME`))
+		}
+
+		offset, terr := getSecondsFromArg(c.Arg(1).String(), false)
+
+		if terr != nil {
+			return NewReply(c, terr)
+		}
+
+		if command == `seekid` {
+			if id, rerr := getSongIdFromCmd(c, 0); rerr == nil {
+				err = self.player.SeekID(id, offset)
+			} else {
+				err = rerr
+			}
+		} else if pos, perr := getSongPosFromArg(arg.String()); perr == nil {
+			err = self.player.Seek(pos, offset)
+		} else {
+			err = perr
+		}
+
+	case `seekcur`:
+		if arg.IsNil() {
+			return NewReply(c, fmt.Errorf("Must specify %q", `TIME`))
+		}
+
+		relative := strings.HasPrefix(arg.String(), `+`) || strings.HasPrefix(arg.String(), `-`)
+
+		if offset, terr := getSecondsFromArg(arg.String(), relative); terr == nil {
+			err = self.player.SeekCurrent(offset, relative)
+		} else {
+			err = terr
+		}
+
+	case `clearerror`:
+		self.player.ClearError()
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+
+	return NewReply(c, err)
+}
 
-	// case `seekcur`:
-	// 	if len(c.Arguments) < 2 {
-	// 		return NewReply(c, fmt.Errorf("Must specify %q and %q", `POS`, `TIME`))
-	// 	}
+// Parses a time in (fractional) seconds, which may only be negative if signed is set.
+func getSecondsFromArg(arg string, signed bool) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(arg, 64)
 
-	// 	offset := time.Duration(c.Arg(1).Float()) * time.Second
-	// 	err = self.Seek(offset)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || (seconds < 0 && !signed) {
+		return 0, fmt.Errorf("Invalid time %q", arg)
+	}
 
-	// default:
-	// 	return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
-	// }
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
-	// return NewReply(c, err)
+// Parses a boolean argument, which (as in MPD) must be either "0" or "1".
+func getBoolFromArg(arg string) (bool, error) {
+	switch arg {
+	case `0`:
+		return false, nil
+	case `1`:
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid boolean value %q", arg)
+	}
 }
@@ -2,110 +2,259 @@ package moped
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ghetzel/moped/library"
 
 	"github.com/ghetzel/go-stockutil/stringutil"
 )
 
-func (self *Moped) cmdPlaylistQueries(c *cmd) *reply {
-	return NotImplemented(c)
-
-	// switch command := c.Command; command {
-	// case `playlist`:
-	// 	return NewReply(c, self.queue.Info())
-
-	// case `playlistinfo`, `playlistid`:
-	// 	if v := c.Arg(0); !v.IsNil() {
-	// 		if info, ok := self.queue.Get(int(v.Int())); ok {
-	// 			return NewReply(c, info)
-	// 		} else if info, ok := self.queue.GetID(library.EntryID(v.Int())); command == `playlistid` && ok {
-	// 			return NewReply(c, info)
-	// 		} else {
-	// 			return NewReply(c, fmt.Errorf("No such song"))
-	// 		}
-	// 	} else {
-	// 		return NewReply(c, self.queue.Info())
-	// 	}
-
-	// case `listplaylists`:
-	// 	return NewReply(c, nil)
-	// default:
-	// 	return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
-	// }
+type queueEntry struct {
+	*QueueItem
+	Position int
 }
 
-func (self *Moped) cmdPlaylistControl(c *cmd) *reply {
-	return NotImplemented(c)
-
-	// var err error
-	// switch command := c.Command; command {
-	// case `add`:
-	// 	err = self.queue.Append(c.Arg(0).String())
-
-	// case `addid`:
-	// 	if len(c.Arguments) < 1 {
-	// 		return NewReply(c, fmt.Errorf("Must specify %q", `URI`))
-	// 	}
-
-	// 	position := -1
-
-	// 	if p := c.Arg(1); !p.IsNil() {
-	// 		position = int(p.Int())
-	// 	}
-
-	// 	err = self.queue.Insert(position, c.Arg(0).String())
-
-	// case `clear`:
-	// 	self.Stop()
-	// 	err = self.queue.Clear()
-
-	// case `delete`, `deleteid`:
-	// 	if start, end, rerr := getRangeFromCmd(c); rerr == nil {
-	// 		err = self.queue.Remove(start, end)
-	// 	} else if id, _, rerr := getEntryIdRangeFromCmd(c); command == `deleteid` && rerr == nil {
-	// 		err = self.queue.RemoveID(id)
-	// 	} else {
-	// 		err = rerr
-	// 	}
-
-	// case `move`, `moveid`:
-	// 	if len(c.Arguments) < 2 {
-	// 		err = fmt.Errorf("Must specify %q/%q and %q", `FROM`, `START:END`, `TO`)
-	// 	} else if from, _, rerr := getEntryIdRangeFromCmd(c); command == `moveid` && rerr == nil {
-	// 		err = self.queue.MoveID(from, int(c.Arg(1).Int()))
-	// 	} else if start, end, rerr := getRangeFromCmd(c); rerr == nil {
-	// 		err = self.queue.Move(start, end, int(c.Arg(1).Int()))
-	// 	} else {
-	// 		err = rerr
-	// 	}
-
-	// case `shuffle`:
-	// 	err = self.queue.Shuffle()
-
-	// case `swap`, `swapid`:
-	// 	if len(c.Arguments) < 2 {
-	// 		err = fmt.Errorf("Must specify %q and %q", `SONG1`, `SONG2`)
-	// 	} else {
-	// 		err = self.queue.Swap(
-	// 			int(c.Arg(0).Int()),
-	// 			int(c.Arg(1).Int()),
-	// 		)
-	// 	}
-	// }
-
-	// return NewReply(c, err)
+func (self *queueEntry) String() string {
+	out := (&dbEntry{Entry: self.Entry}).String()
+
+	if self.HasRange() {
+		out += fmt.Sprintf("Range: %s-", formatSeconds(self.Start))
+
+		if self.End > 0 {
+			out += formatSeconds(self.End)
+		}
+
+		out += "\n"
+	}
+
+	out += fmt.Sprintf("Pos: %d\n", self.Position)
+	out += fmt.Sprintf("Id: %d\n", self.SongID)
+
+	if self.Priority > 0 {
+		out += fmt.Sprintf("Prio: %d\n", self.Priority)
+	}
+
+	return out
+}
+
+func (self *Partition) queueEntries(start int, end int) []*queueEntry {
+	results := make([]*queueEntry, 0)
+
+	for i, item := range self.queue.Items() {
+		if i >= start && (end < 0 || i < end) {
+			results = append(results, &queueEntry{
+				QueueItem: item,
+				Position:  i,
+			})
+		}
+	}
+
+	return results
+}
+
+func (self *Partition) cmdPlaylistQueries(c *cmd) *reply {
+	switch command := c.Command; command {
+	case `playlist`:
+		lines := make([]string, 0)
+
+		for i, item := range self.queue.Items() {
+			lines = append(lines, fmt.Sprintf("%d:file: %v", i, item.FileRetrievalPath()))
+		}
+
+		return NewReply(c, lines)
+
+	case `playlistinfo`:
+		if len(c.Arguments) > 0 {
+			if start, end, err := getRangeFromCmd(c); err == nil {
+				if _, ok := self.queue.Get(start); !ok {
+					return NewReply(c, fmt.Errorf("Bad song index"))
+				}
+
+				return NewReply(c, self.queueEntries(start, end))
+			} else {
+				return NewReply(c, err)
+			}
+		} else {
+			return NewReply(c, self.queueEntries(0, -1))
+		}
+
+	case `playlistid`:
+		if len(c.Arguments) > 0 {
+			if id, err := getSongIdFromCmd(c, 0); err == nil {
+				if pos := self.queue.Position(id); pos >= 0 {
+					return NewReply(c, self.queueEntries(pos, pos+1))
+				} else {
+					return NewReply(c, fmt.Errorf("No such song"))
+				}
+			} else {
+				return NewReply(c, err)
+			}
+		} else {
+			return NewReply(c, self.queueEntries(0, -1))
+		}
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
 }
 
+func (self *Partition) cmdPlaylistControl(c *cmd) *reply {
+	var err error
+
+	switch command := c.Command; command {
+	case `add`, `addid`:
+		if len(c.Arguments) < 1 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `URI`))
+		}
+
+		position := -1
+
+		if p := c.Arg(1); !p.IsNil() {
+			if pos, perr := self.getPositionFromArg(p.String()); perr == nil {
+				position = pos
+			} else {
+				return NewReply(c, perr)
+			}
+		}
+
+		var ids []library.EntryID
+		var aerr error
+
+		// addid only takes a single song, which is added as-is rather than walked like a folder
+		if command == `addid` {
+			if uri := c.Arg(0).String(); library.IsRemoteLocation(uri) {
+				ids, aerr = self.queue.AddEntries(library.EntryList{library.NewRemoteEntry(uri)}, position)
+			} else if entry, gerr := self.Get(uri); gerr != nil {
+				return NewReply(c, gerr)
+			} else if !entry.IsContent() {
+				return NewReply(c, fmt.Errorf("%q is not a song", c.Arg(0).String()))
+			} else {
+				ids, aerr = self.queue.AddEntries(library.EntryList{entry}, position)
+			}
+		} else {
+			ids, aerr = self.queue.Add(c.Arg(0).String(), position)
+		}
+
+		if aerr == nil {
+			if command == `addid` {
+				return NewReply(c, map[string]interface{}{
+					`Id`: ids[0],
+				})
+			}
+		} else {
+			err = aerr
+		}
+
+	case `clear`:
+		err = self.player.Stop()
+		self.queue.Clear()
+
+	case `delete`:
+		if start, end, rerr := getRangeFromCmd(c); rerr == nil {
+			if err = self.queue.Remove(start, end); err == nil {
+				err = self.player.Sync()
+			}
+		} else {
+			err = rerr
+		}
+
+	case `deleteid`:
+		if id, rerr := getSongIdFromCmd(c, 0); rerr == nil {
+			if err = self.queue.RemoveID(id); err == nil {
+				err = self.player.Sync()
+			}
+		} else {
+			err = rerr
+		}
+
+	case `move`, `moveid`:
+		if len(c.Arguments) < 2 {
+			err = fmt.Errorf("Must specify %q/%q and %q", `FROM`, `START:END`, `TO`)
+		} else if to, rerr := self.getPositionFromArg(c.Arg(1).String()); rerr != nil {
+			err = rerr
+		} else if command == `moveid` {
+			if id, rerr := getSongIdFromCmd(c, 0); rerr == nil {
+				err = self.queue.MoveID(id, to)
+			} else {
+				err = rerr
+			}
+		} else if start, end, rerr := getRangeFromCmd(c); rerr == nil {
+			err = self.queue.Move(start, end, to)
+		} else {
+			err = rerr
+		}
+
+	case `shuffle`:
+		if len(c.Arguments) > 0 {
+			if start, end, rerr := getRangeFromCmd(c); rerr == nil {
+				err = self.queue.Shuffle(start, end)
+			} else {
+				err = rerr
+			}
+		} else if self.queue.Len() > 0 {
+			err = self.queue.Shuffle(0, -1)
+		}
+
+	case `swap`:
+		if len(c.Arguments) < 2 {
+			err = fmt.Errorf("Must specify %q and %q", `SONG1`, `SONG2`)
+		} else if a, rerr := getSongPosFromArg(c.Arg(0).String()); rerr != nil {
+			err = rerr
+		} else if b, rerr := getSongPosFromArg(c.Arg(1).String()); rerr != nil {
+			err = rerr
+		} else {
+			err = self.queue.Swap(a, b)
+		}
+
+	case `swapid`:
+		if len(c.Arguments) < 2 {
+			err = fmt.Errorf("Must specify %q and %q", `SONG1`, `SONG2`)
+		} else if a, rerr := getSongIdFromCmd(c, 0); rerr != nil {
+			err = rerr
+		} else if b, rerr := getSongIdFromCmd(c, 1); rerr != nil {
+			err = rerr
+		} else {
+			err = self.queue.SwapID(a, b)
+		}
+
+	case `rangeid`:
+		if len(c.Arguments) < 2 {
+			err = fmt.Errorf("Must specify %q and %q", `ID`, `START:END`)
+		} else if id, rerr := getSongIdFromCmd(c, 0); rerr != nil {
+			err = rerr
+		} else if start, end, rerr := getTimeRangeFromArg(c.Arg(1).String()); rerr != nil {
+			err = rerr
+		} else {
+			err = self.queue.SetRange(id, start, end)
+		}
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+
+	return NewReply(c, err)
+}
+
+// Parses the first argument of the given command as either a single position ("POS") or a
+// range of positions ("START:END").  A single position is returned as the range [POS, POS+1),
+// and an omitted END is returned as -1.
 func getRangeFromCmd(c *cmd) (int, int, error) {
 	if len(c.Arguments) < 1 {
 		return 0, 0, fmt.Errorf("Must specify %q or %q", `POS`, `START:END`)
 	}
 
+	return getRangeFromArg(c.Arg(0).String())
+}
+
+// Parses a position or range of positions, as per getRangeFromCmd.  Negative positions and ranges
+// that end before they start are rejected.
+func getRangeFromArg(arg string) (int, int, error) {
 	var start int
 	var end int
 
-	a, b := stringutil.SplitPair(c.Arg(0).String(), `:`)
+	a, b := stringutil.SplitPair(arg, `:`)
 
 	if a != `` {
 		if v, err := stringutil.ConvertToInteger(a); err == nil {
@@ -121,14 +270,95 @@ func getRangeFromCmd(c *cmd) (int, int, error) {
 		} else {
 			return 0, 0, err
 		}
-	} else {
+	} else if strings.Contains(arg, `:`) {
 		end = -1
+	} else {
+		end = start + 1
+	}
+
+	if start < 0 || (end >= 0 && end < start) || (end < 0 && b != ``) {
+		return 0, 0, fmt.Errorf("Bad song index")
 	}
 
 	return start, end, nil
 }
 
-func getEntryIdRangeFromCmd(c *cmd) (library.EntryID, library.EntryID, error) {
-	start, end, err := getRangeFromCmd(c)
-	return library.EntryID(start), library.EntryID(end), err
+// Parses a "START:END" range of fractional seconds, either of which may be omitted.
+func getTimeRangeFromArg(arg string) (time.Duration, time.Duration, error) {
+	var bounds [2]time.Duration
+
+	if !strings.Contains(arg, `:`) {
+		return 0, 0, fmt.Errorf("Must specify a range as %q", `START:END`)
+	}
+
+	a, b := stringutil.SplitPair(arg, `:`)
+
+	for i, v := range []string{a, b} {
+		if v != `` {
+			if seconds, err := stringutil.ConvertToFloat(v); err == nil && seconds >= 0 {
+				bounds[i] = time.Duration(seconds * float64(time.Second))
+			} else {
+				return 0, 0, fmt.Errorf("Invalid time %q", v)
+			}
+		}
+	}
+
+	return bounds[0], bounds[1], nil
+}
+
+// Parses the position that songs are inserted at, which is either absolute ("POS") or relative to
+// the current song: "+N" is N songs after it (so "+0" is right after it) and "-N" is N songs
+// before it (so "-0" is right before it).
+func (self *Partition) getPositionFromArg(arg string) (int, error) {
+	relative := strings.HasPrefix(arg, `+`) || strings.HasPrefix(arg, `-`)
+	digits := arg
+
+	if relative {
+		digits = arg[1:]
+	}
+
+	n, err := strconv.Atoi(digits)
+
+	if err != nil || n < 0 || strings.HasPrefix(digits, `+`) || strings.HasPrefix(digits, `-`) {
+		return 0, fmt.Errorf("Invalid position %q", arg)
+	} else if !relative {
+		return n, nil
+	}
+
+	current := self.queue.Index()
+
+	if current < 0 {
+		return 0, fmt.Errorf("No current song")
+	} else if arg[0] == '+' {
+		return current + 1 + n, nil
+	} else if current-n < 0 {
+		return 0, fmt.Errorf("Bad song index")
+	} else {
+		return current - n, nil
+	}
+}
+
+// Parses the (absolute) position of a song in the queue.
+func getSongPosFromArg(arg string) (int, error) {
+	if pos, err := strconv.Atoi(arg); err == nil && pos >= 0 {
+		return pos, nil
+	}
+
+	return 0, fmt.Errorf("Invalid song position %q", arg)
+}
+
+func getSongIdFromCmd(c *cmd, i int) (library.EntryID, error) {
+	if arg := c.Arg(i); !arg.IsNil() {
+		if v, err := stringutil.ConvertToInteger(arg.String()); err == nil && v > 0 {
+			return library.EntryID(v), nil
+		}
+
+		return 0, fmt.Errorf("Invalid song ID %q", arg.String())
+	}
+
+	return 0, fmt.Errorf("Must specify %q", `SONGID`)
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
 }
@@ -0,0 +1,272 @@
+package moped
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ghetzel/moped/library"
+)
+
+func (self *Moped) cmdStoredPlaylists(c *cmd) *reply {
+	if self.playlists == nil {
+		return NewReply(c, fmt.Errorf("Stored playlists are disabled"))
+	}
+
+	name := c.Arg(0).String()
+
+	if c.Command != `listplaylists` && name == `` {
+		return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+	}
+
+	var err error
+
+	switch command := c.Command; command {
+	case `listplaylists`:
+		if playlists, lerr := self.playlists.List(); lerr == nil {
+			return NewReply(c, playlists)
+		} else {
+			err = lerr
+		}
+
+	case `listplaylist`, `listplaylistinfo`:
+		if uris, lerr := self.loadStoredPlaylist(name, c.Arg(1).String()); lerr == nil {
+			if command == `listplaylist` {
+				lines := make([]string, len(uris))
+
+				for i, uri := range uris {
+					lines[i] = fmt.Sprintf("file: %v", uri)
+				}
+
+				return NewReply(c, lines)
+			}
+
+			results := make([]*dbEntry, len(uris))
+
+			for i, uri := range uris {
+				// songs that are no longer in the library are still listed, just without metadata
+				entry, gerr := self.Get(uri)
+
+				if gerr != nil {
+					entry = &library.Entry{
+						Path: uri,
+						Type: library.AudioEntry,
+					}
+				}
+
+				results[i] = &dbEntry{
+					Entry: entry,
+				}
+			}
+
+			return NewReply(c, results)
+		} else {
+			err = lerr
+		}
+
+	case `load`:
+		partition := self.clientPartition(c.Client)
+		position := -1
+
+		if p := c.Arg(2); !p.IsNil() {
+			if pos, perr := partition.getPositionFromArg(p.String()); perr == nil {
+				position = pos
+			} else {
+				return NewReply(c, perr)
+			}
+		}
+
+		if uris, lerr := self.loadStoredPlaylist(name, c.Arg(1).String()); lerr == nil {
+			entries := make(library.EntryList, 0, len(uris))
+
+			// every song is resolved before any of them are queued, so that a playlist is either
+			// loaded completely or not at all
+			for _, uri := range uris {
+				if resolved, rerr := partition.queue.resolve(uri); rerr != nil {
+					return NewReply(c, fmt.Errorf("Cannot load %v from playlist %q: %v", uri, name, rerr))
+				} else if len(resolved) == 0 {
+					return NewReply(c, fmt.Errorf("No songs found at %q", uri))
+				} else {
+					entries = append(entries, resolved...)
+				}
+			}
+
+			_, err = partition.queue.AddEntries(entries, position)
+		} else {
+			err = lerr
+		}
+
+	case `save`:
+		mode := c.Arg(1).String()
+		uris := make([]string, 0)
+
+		for _, item := range self.clientPartition(c.Client).queue.Items() {
+			uris = append(uris, item.FullPath())
+		}
+
+		switch mode {
+		case ``, `create`:
+			err = self.playlists.Create(name, uris)
+		case `replace`:
+			err = self.playlists.Save(name, uris)
+		case `append`:
+			err = self.playlists.Update(name, true, func(existing []string) ([]string, error) {
+				return append(existing, uris...), nil
+			})
+		default:
+			return NewReply(c, fmt.Errorf("Invalid save mode %q", mode))
+		}
+
+	case `playlistadd`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `NAME`, `URI`))
+		}
+
+		uris := make([]string, 0)
+
+		// adding a folder adds all of the songs in it (URLs are added as they are)
+		if uri := c.Arg(1).String(); library.IsRemoteLocation(uri) {
+			uris = append(uris, uri)
+		} else if werr := self.Walk(uri, func(entry *library.Entry) error {
+			if entry.IsContent() {
+				uris = append(uris, entry.FullPath())
+			}
+
+			return nil
+		}); werr != nil {
+			return NewReply(c, werr)
+		} else if len(uris) == 0 {
+			return NewReply(c, fmt.Errorf("No songs found at %q", uri))
+		}
+
+		err = self.playlists.Update(name, true, func(existing []string) ([]string, error) {
+			position := len(existing)
+
+			if p := c.Arg(2); !p.IsNil() {
+				if pos, perr := strconv.Atoi(p.String()); perr != nil {
+					return nil, fmt.Errorf("Invalid song position %q", p.String())
+				} else if pos < 0 || pos > len(existing) {
+					return nil, fmt.Errorf("Bad song index")
+				} else {
+					position = pos
+				}
+			}
+
+			updated := make([]string, 0, len(existing)+len(uris))
+			updated = append(updated, existing[:position]...)
+			updated = append(updated, uris...)
+			updated = append(updated, existing[position:]...)
+
+			return updated, nil
+		})
+
+	case `playlistclear`:
+		err = self.playlists.Update(name, false, func(existing []string) ([]string, error) {
+			return nil, nil
+		})
+
+	case `playlistdelete`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `NAME`, `SONGPOS`))
+		}
+
+		err = self.playlists.Update(name, false, func(existing []string) ([]string, error) {
+			if start, end, rerr := getPlaylistRange(c.Arg(1).String(), len(existing)); rerr == nil {
+				return append(existing[:start], existing[end:]...), nil
+			} else {
+				return nil, rerr
+			}
+		})
+
+	case `playlistmove`:
+		if len(c.Arguments) < 3 {
+			return NewReply(c, fmt.Errorf("Must specify %q, %q and %q", `NAME`, `FROM`, `TO`))
+		}
+
+		to, perr := strconv.Atoi(c.Arg(2).String())
+
+		if perr != nil {
+			return NewReply(c, fmt.Errorf("Invalid song position %q", c.Arg(2).String()))
+		}
+
+		err = self.playlists.Update(name, false, func(existing []string) ([]string, error) {
+			start, end, rerr := getPlaylistRange(c.Arg(1).String(), len(existing))
+
+			if rerr != nil {
+				return nil, rerr
+			}
+
+			moving := append([]string{}, existing[start:end]...)
+			rest := append(append([]string{}, existing[:start]...), existing[end:]...)
+
+			if to >= 0 && to <= len(rest) {
+				updated := make([]string, 0, len(existing))
+				updated = append(updated, rest[:to]...)
+				updated = append(updated, moving...)
+				updated = append(updated, rest[to:]...)
+
+				return updated, nil
+			}
+
+			return nil, fmt.Errorf("Bad song index")
+		})
+
+	case `rename`:
+		if len(c.Arguments) < 2 {
+			return NewReply(c, fmt.Errorf("Must specify %q and %q", `NAME`, `NEW_NAME`))
+		}
+
+		err = self.playlists.Rename(name, c.Arg(1).String())
+
+	case `rm`:
+		err = self.playlists.Delete(name)
+
+	default:
+		return NewReply(c, fmt.Errorf("Unsupported command %q", c.Command))
+	}
+
+	if err == nil {
+		switch c.Command {
+		case `listplaylists`, `listplaylist`, `listplaylistinfo`, `load`:
+		default:
+			self.AddChangedSubsystem(`stored_playlist`)
+		}
+	}
+
+	return NewReply(c, err)
+}
+
+// Returns the songs in a stored playlist, or only those in the given range ("START:END") of
+// positions if one is given.
+func (self *Moped) loadStoredPlaylist(name string, span string) ([]string, error) {
+	uris, err := self.playlists.Load(name)
+
+	if err != nil || span == `` {
+		return uris, err
+	}
+
+	if start, end, err := getPlaylistRange(span, len(uris)); err == nil {
+		return uris[start:end], nil
+	} else {
+		return nil, err
+	}
+}
+
+// Parses a position or range of positions in a playlist of the given length, returning an error
+// if any part of it is out of bounds.
+func getPlaylistRange(arg string, length int) (int, int, error) {
+	start, end, err := getRangeFromArg(arg)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < 0 {
+		end = length
+	}
+
+	if start < 0 || start >= length || end > length || end < start {
+		return 0, 0, fmt.Errorf("Bad song index")
+	}
+
+	return start, end, nil
+}
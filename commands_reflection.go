@@ -3,7 +3,9 @@ package moped
 import (
 	"sort"
 
+	"github.com/ghetzel/go-stockutil/log"
 	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/moped/audio"
 )
 
 func (self *Moped) cmdReflectCommands(c *cmd) *reply {
@@ -29,22 +31,22 @@ func (self *Moped) cmdReflectUrlHandlers(c *cmd) *reply {
 }
 
 func (self *Moped) cmdReflectDecoders(c *cmd) *reply {
-	return NewReply(c, map[string]interface{}{
-		`mime_type`: []string{
-			`audio/flac`,
-			`audio/mpeg`,
-			`audio/x-flac`,
-			`audio/x-mpeg`,
-			`audio/x-wav`,
-		},
-		`suffix`: []string{
-			`flac`,
-			`mp3`,
-			`wav`,
-		},
-		`plugin`: []string{
-			`pcm`,
-			`flac`,
-		},
-	})
+	if caps, err := audio.GetCapabilities(); err == nil {
+		lines := []string{
+			`plugin: ffmpeg`,
+		}
+
+		for _, suffix := range caps.Suffixes {
+			lines = append(lines, `suffix: `+suffix)
+		}
+
+		for _, mimetype := range caps.MimeTypes {
+			lines = append(lines, `mime_type: `+mimetype)
+		}
+
+		return NewReply(c, lines)
+	} else {
+		log.Warningf("Cannot determine decoder capabilities: %v", err)
+		return NewReply(c, nil)
+	}
 }
@@ -8,7 +8,8 @@ import (
 
 type cmdHandler func(*cmd) *reply
 
-// Reports the current status of the player and the volume level.
+// Reports the current status of the player and the volume level (of the client's partition).
+// - partition:      the name of the partition
 // - volume:         0-100 or -1 if the volume cannot be determined
 // - repeat:         0 or 1
 // - random:         0 or 1
@@ -33,83 +34,79 @@ type cmdHandler func(*cmd) *reply
 // - updating_db:    job id
 // - error:          if there is an error, returns message here
 //
-func (self *Moped) cmdStatus(c *cmd) *reply {
+func (self *Partition) cmdStatus(c *cmd) *reply {
+	state := self.player.State()
+	mode := self.Playmode()
+
 	data := map[string]interface{}{
-		`volume`:         -1,
-		`repeat`:         b2i(false), // b2i(self.playmode.Repeat),
-		`random`:         b2i(false), // b2i(self.playmode.Random),
-		`single`:         b2i(false), // b2i(self.playmode.Single),
-		`consume`:        b2i(false), // b2i(self.playmode.Consume),
-		`playlist`:       1,
-		`playlistlength`: 0, // self.queue.Len(),
-		`mixrampdb`:      `0.000000`,
-		`state`:          `stop`,
-		`song`:           0,
-		`songid`:         0,
+		`partition`:      self.Name,
+		`volume`:         self.mixer.Volume(),
+		`repeat`:         b2i(mode.Repeat),
+		`random`:         b2i(mode.Random),
+		`single`:         mode.Single.String(),
+		`consume`:        b2i(mode.Consume),
+		`playlist`:       self.queue.Version(),
+		`playlistlength`: self.queue.Len(),
+		`mixrampdb`:      fmt.Sprintf("%f", mode.MixRampDB),
+		`state`:          state.String(),
 	}
 
-	// if next, ok := self.queue.Peek(); ok {
-	// 	data[`nextsong`] = self.queue.Index() + 1
-	// 	data[`nextsongid`] = next.ID()
-	// }
-
-	// switch self.state {
-	// case StatePlaying, StatePaused:
-	// 	position := self.Position()
-	// 	length := self.Length()
-
-	// 	data[`time`] = fmt.Sprintf(
-	// 		"%d:%d",
-	// 		int(position.Truncate(time.Second)/time.Second),
-	// 		int(length.Truncate(time.Second)/time.Second),
-	// 	)
-	// 	data[`elapsed`] = float64(position / time.Second)
-	// 	data[`duration`] = float64(length / time.Second)
-	// }
-
-	return NewReply(c, data)
-}
+	if mode.Crossfade > 0 {
+		data[`xfade`] = mode.Crossfade
+	}
 
-func (self *Moped) cmdCurrentSong(c *cmd) *reply {
-	return NotImplemented(c)
+	if mode.MixRamp {
+		data[`mixrampdelay`] = fmt.Sprintf("%f", mode.MixRampDelay)
+	}
 
-	// status := make(map[string]interface{})
+	if job := self.updater.Current(); job > 0 {
+		data[`updating_db`] = job
+	}
 
-	// if current, ok := self.queue.Current(); ok {
-	// 	status[`file`] = current.Path
+	if current, ok := self.queue.Current(); ok {
+		data[`song`] = self.queue.Position(current.SongID)
+		data[`songid`] = current.SongID
+	}
 
-	// 	if v := current.Metadata.Year; v > 0 {
-	// 		status[`Date`] = v
-	// 	}
+	if next, ok := self.player.Peek(); ok {
+		data[`nextsong`] = self.queue.Position(next.SongID)
+		data[`nextsongid`] = next.SongID
+	}
 
-	// 	if v := current.Metadata.Track; v > 0 {
-	// 		status[`Track`] = v
-	// 	}
+	switch state {
+	case StatePlaying, StatePaused:
+		position := self.player.Elapsed()
+		length := self.player.Duration()
 
-	// 	if v := current.Metadata.Album; v != `` {
-	// 		status[`Album`] = v
-	// 	}
+		data[`time`] = fmt.Sprintf(
+			"%d:%d",
+			int(position.Truncate(time.Second)/time.Second),
+			int(length.Round(time.Second)/time.Second),
+		)
 
-	// 	if v := current.Metadata.Artist; v != `` {
-	// 		status[`Artist`] = v
-	// 	}
+		data[`elapsed`] = formatSeconds(position)
 
-	// 	if v := current.Metadata.Title; v != `` {
-	// 		status[`Title`] = v
-	// 	}
+		if length > 0 {
+			data[`duration`] = formatSeconds(length)
+		}
+	}
 
-	// 	if v := current.Metadata.Duration; v > 0 {
-	// 		status[`Time`] = int(v.Round(time.Second) / time.Second)
-	// 		status[`duration`] = float64(v.Round(time.Millisecond) / time.Millisecond)
-	// 	}
+	if err := self.player.Error(); err != nil {
+		data[`error`] = err.Error()
+	}
 
-	// 	status[`Pos`] = self.queue.Index()
-	// 	status[`Id`] = current.ID()
+	return NewReply(c, data)
+}
 
-	// 	return NewReply(c, status)
-	// } else {
-	// 	return NewReply(c, nil)
-	// }
+func (self *Partition) cmdCurrentSong(c *cmd) *reply {
+	if current, ok := self.queue.Current(); ok {
+		return NewReply(c, &queueEntry{
+			QueueItem: current,
+			Position:  self.queue.Position(current.SongID),
+		})
+	} else {
+		return NewReply(c, nil)
+	}
 }
 
 func b2i(in bool) int {
@@ -127,17 +124,45 @@ func b2i(in bool) int {
 // - uptime:      daemon uptime in seconds
 // - db_playtime: sum of all song times in the db
 // - db_update:   last db update in UNIX time
-// - playtime:    time length of music played
+// - playtime:    time length of music played (in all partitions)
 //
 func (self *Moped) cmdStats(c *cmd) *reply {
+	entries, err := self.index.Entries()
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	artists := make(map[string]bool)
+	albums := make(map[string]bool)
+	var playtime time.Duration
+
+	for _, entry := range entries {
+		if artist := entry.Metadata.Artist; artist != `` {
+			artists[artist] = true
+		}
+
+		if album := entry.Metadata.Album; album != `` {
+			albums[album] = true
+		}
+
+		playtime += entry.Duration()
+	}
+
+	var played time.Duration
+
+	for _, partition := range self.Partitions() {
+		played += partition.player.Playtime()
+	}
+
 	return NewReply(c, map[string]interface{}{
-		`artists`:     1,
-		`albums`:      1,
-		`songs`:       1,
+		`artists`:     len(artists),
+		`albums`:      len(albums),
+		`songs`:       len(entries),
 		`uptime`:      int(time.Since(self.startedAt).Seconds()),
-		`db_playtime`: 0,
-		`db_update`:   time.Now().Unix(),
-		`playtime`:    0,
+		`db_playtime`: int(playtime.Round(time.Second) / time.Second),
+		`db_update`:   self.LastUpdated().Unix(),
+		`playtime`:    int(played / time.Second),
 	})
 }
 
@@ -156,6 +181,7 @@ func (self *Moped) cmdStats(c *cmd) *reply {
 //   options:         options like repeat, random, crossfade, replay gain
 //   partition:       a partition was added, removed or changed
 //   sticker:         the sticker database has been modified.
+//   mount:           a library was mounted or unmounted
 //   subscription:    a client has subscribed or unsubscribed to a channel
 //   message:         a message was received on a channel this client is subscribed to;
 //                    this event is only emitted when the queue is empty
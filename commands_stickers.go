@@ -0,0 +1,265 @@
+package moped
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type stickerMatch struct {
+	URI   string
+	Name  string
+	Value string
+}
+
+func (self *stickerMatch) String() string {
+	return fmt.Sprintf("file: %v\nsticker: %v=%v\n", self.URI, self.Name, self.Value)
+}
+
+// Handles "sticker get|set|inc|dec|delete|list|find TYPE URI [NAME] ...".
+func (self *Moped) cmdSticker(c *cmd) *reply {
+	if self.db == nil {
+		return NewReply(c, fmt.Errorf("Stickers are disabled"))
+	}
+
+	action := c.Arg(0).String()
+	objtype := c.Arg(1).String()
+	name := c.Arg(3).String()
+
+	if len(c.Arguments) < 3 {
+		return NewReply(c, fmt.Errorf("Must specify %q, %q and %q", `ACTION`, `TYPE`, `URI`))
+	}
+
+	uri, err := self.stickerURI(objtype, c.Arg(2).String())
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	switch action {
+	case `get`, `set`, `inc`, `dec`, `find`:
+		if name == `` {
+			return NewReply(c, fmt.Errorf("Must specify %q", `NAME`))
+		}
+	}
+
+	switch action {
+	case `get`:
+		if value, found, err := self.db.Sticker(objtype, uri, name); err != nil {
+			return NewReply(c, err)
+		} else if !found {
+			return NewReply(c, fmt.Errorf("No such sticker"))
+		} else {
+			return NewReply(c, fmt.Sprintf("sticker: %v=%v", name, value))
+		}
+
+	case `list`:
+		if stickers, err := self.db.Stickers(objtype, uri); err == nil {
+			lines := make([]string, 0, len(stickers))
+
+			for name, value := range stickers {
+				lines = append(lines, fmt.Sprintf("sticker: %v=%v", name, value))
+			}
+
+			sort.Strings(lines)
+			return NewReply(c, lines)
+		} else {
+			return NewReply(c, err)
+		}
+
+	case `set`, `inc`, `dec`:
+		value := c.Arg(4).String()
+
+		if action == `set` && len(c.Arguments) < 5 {
+			return NewReply(c, fmt.Errorf("Must specify %q", `VALUE`))
+		}
+
+		// stickers can only be attached to songs that exist
+		if entry, gerr := self.Get(uri); gerr != nil {
+			return NewReply(c, gerr)
+		} else if !entry.IsContent() {
+			return NewReply(c, fmt.Errorf("%q is not a song", uri))
+		}
+
+		err = self.db.UpdateSticker(objtype, uri, name, func(current string, found bool) (string, error) {
+			if action == `set` {
+				return value, nil
+			}
+
+			// inc and dec treat missing (or non-numeric) stickers as zero, and change them by one
+			// unless told otherwise
+			delta := int64(1)
+
+			if value != `` {
+				if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+					delta = v
+				} else {
+					return ``, fmt.Errorf("Invalid number %q", value)
+				}
+			}
+
+			if action == `dec` {
+				delta = -delta
+			}
+
+			n, _ := strconv.ParseInt(current, 10, 64)
+
+			return strconv.FormatInt(n+delta, 10), nil
+		})
+
+	case `delete`:
+		if found, derr := self.db.DeleteSticker(objtype, uri, name); derr != nil {
+			err = derr
+		} else if !found {
+			err = fmt.Errorf("No such sticker")
+		}
+
+	case `find`:
+		return self.findStickers(c, objtype, uri, name, c.Arguments[4:])
+
+	default:
+		return NewReply(c, fmt.Errorf("Unknown sticker command %q", action))
+	}
+
+	if err == nil {
+		self.AddChangedSubsystem(`sticker`)
+	}
+
+	return NewReply(c, err)
+}
+
+// Handles "sticker find TYPE URI NAME [OP VALUE] [sort SORTTYPE] [window START:END]".  The
+// operators "=", "<", ">", "contains" and "starts_with" compare values as strings, and "eq",
+// "lt" and "gt" compare them as integers.  Results can be sorted by "uri", "value" or
+// "value_int", optionally prefixed with "-" for descending order.
+func (self *Moped) findStickers(c *cmd, objtype string, base string, name string, args []string) *reply {
+	match := func(value string) bool {
+		return true
+	}
+
+	if len(args) >= 2 && args[0] != `sort` && args[0] != `window` {
+		op, want := args[0], args[1]
+		args = args[2:]
+		wantInt, intErr := strconv.ParseInt(want, 10, 64)
+
+		switch op {
+		case `=`:
+			match = func(value string) bool { return value == want }
+		case `<`:
+			match = func(value string) bool { return value < want }
+		case `>`:
+			match = func(value string) bool { return value > want }
+		case `contains`:
+			match = func(value string) bool { return strings.Contains(value, want) }
+		case `starts_with`:
+			match = func(value string) bool { return strings.HasPrefix(value, want) }
+		case `eq`, `lt`, `gt`:
+			if intErr != nil {
+				return NewReply(c, fmt.Errorf("Invalid number %q", want))
+			}
+
+			match = func(value string) bool {
+				if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+					switch op {
+					case `eq`:
+						return v == wantInt
+					case `lt`:
+						return v < wantInt
+					default:
+						return v > wantInt
+					}
+				}
+
+				return false
+			}
+		default:
+			return NewReply(c, fmt.Errorf("Bad operator %q", op))
+		}
+	}
+
+	sortBy := `uri`
+	descending := false
+	start, end := 0, -1
+
+	for ; len(args) >= 2; args = args[2:] {
+		switch args[0] {
+		case `sort`:
+			sortBy = strings.TrimPrefix(args[1], `-`)
+			descending = strings.HasPrefix(args[1], `-`)
+		case `window`:
+			var err error
+
+			if start, end, err = getRangeFromArg(args[1]); err != nil {
+				return NewReply(c, err)
+			}
+		default:
+			return NewReply(c, fmt.Errorf("Unexpected argument %q", args[0]))
+		}
+	}
+
+	if len(args) > 0 {
+		return NewReply(c, fmt.Errorf("Unexpected argument %q", args[0]))
+	}
+
+	found, err := self.db.FindStickers(objtype, base, name)
+
+	if err != nil {
+		return NewReply(c, err)
+	}
+
+	results := make([]*stickerMatch, 0, len(found))
+
+	for uri, value := range found {
+		if match(value) {
+			results = append(results, &stickerMatch{
+				URI:   uri,
+				Name:  name,
+				Value: value,
+			})
+		}
+	}
+
+	var less func(a *stickerMatch, b *stickerMatch) bool
+
+	switch sortBy {
+	case `uri`:
+		less = func(a *stickerMatch, b *stickerMatch) bool { return a.URI < b.URI }
+	case `value`:
+		less = func(a *stickerMatch, b *stickerMatch) bool { return a.Value < b.Value }
+	case `value_int`:
+		less = func(a *stickerMatch, b *stickerMatch) bool {
+			x, _ := strconv.ParseInt(a.Value, 10, 64)
+			y, _ := strconv.ParseInt(b.Value, 10, 64)
+			return x < y
+		}
+	default:
+		return NewReply(c, fmt.Errorf("Invalid sort type %q", sortBy))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].URI < results[j].URI
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if descending {
+			return less(results[j], results[i])
+		}
+
+		return less(results[i], results[j])
+	})
+
+	if start > len(results) {
+		start = len(results)
+	}
+
+	if end < 0 || end > len(results) {
+		end = len(results)
+	}
+
+	if end < start {
+		end = start
+	}
+
+	return NewReply(c, results[start:end])
+}
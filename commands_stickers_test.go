@@ -0,0 +1,85 @@
+package moped
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindStickersWindow(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `moped-stickers`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(filepath.Join(dir, `moped.db`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	for _, uri := range []string{`music/a.flac`, `music/b.flac`, `music/c.flac`} {
+		if err := db.UpdateSticker(`song`, uri, `rating`, func(string, bool) (string, error) {
+			return `5`, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := &Moped{
+		db: db,
+	}
+
+	tests := []struct {
+		name   string
+		window string
+		want   []string
+		err    bool
+	}{
+		{name: `all`, window: `0:`, want: []string{`music/a.flac`, `music/b.flac`, `music/c.flac`}},
+		{name: `range`, window: `1:2`, want: []string{`music/b.flac`}},
+		{name: `past the end`, window: `2:9`, want: []string{`music/c.flac`}},
+		{name: `starting past the end`, window: `5:9`, want: []string{}},
+		{name: `negative start`, window: `-1:3`, err: true},
+		{name: `negative end`, window: `0:-1`, err: true},
+		{name: `inverted`, window: `2:1`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cmd{
+				Command:   `sticker`,
+				Arguments: []string{`find`, `song`, ``, `rating`, `window`, tt.window},
+			}
+
+			r := app.findStickers(c, `song`, ``, `rating`, []string{`window`, tt.window})
+
+			if tt.err {
+				if !r.IsError() {
+					t.Errorf("window %q was accepted: %v", tt.window, r.Body)
+				}
+
+				return
+			} else if r.IsError() {
+				t.Fatalf("window %q was rejected: %v", tt.window, r.Body)
+			}
+
+			got := make([]string, 0)
+
+			for _, match := range r.Body.([]*stickerMatch) {
+				got = append(got, match.URI)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("window %q gave %q, want %q", tt.window, got, tt.want)
+			}
+		})
+	}
+}
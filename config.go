@@ -6,12 +6,14 @@ import (
 	"os"
 	"regexp"
 
-	"github.com/ghetzel/go-stockutil/maputil"
 	"github.com/ghetzel/go-stockutil/pathutil"
+	"github.com/ghetzel/moped/audio"
 	"github.com/ghetzel/moped/backends"
 	"github.com/ghetzel/moped/library"
 	"github.com/ghetzel/moped/metadata"
+	"github.com/ghetzel/moped/outputs"
 	"github.com/ghodss/yaml"
+	"github.com/mcuadros/go-defaults"
 )
 
 type LibraryConfig struct {
@@ -20,9 +22,70 @@ type LibraryConfig struct {
 	Configuration map[string]interface{} `json:"config"`
 }
 
+type OutputConfig struct {
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Enabled       *bool                  `json:"enabled,omitempty"`
+	Configuration map[string]interface{} `json:"config"`
+}
+
+type ReplayGainConfig struct {
+	Mode          string  `json:"mode"`
+	Preamp        float64 `json:"preamp"`
+	MissingPreamp float64 `json:"missing_preamp"`
+	Limit         *bool   `json:"limit,omitempty"`
+}
+
 type Configuration struct {
-	Libraries []LibraryConfig `json:"libraries"`
-	Patterns  []string        `json:"patterns"`
+	Libraries         []LibraryConfig  `json:"libraries"`
+	Outputs           []OutputConfig   `json:"outputs"`
+	AudioOutputFormat string           `json:"audio_output_format"`
+	StateFile         string           `json:"state_file" default:"~/.config/moped/state.json"`
+	Database          string           `json:"database" default:"~/.cache/moped/database.db"`
+	PlaylistDirectory string           `json:"playlist_directory" default:"~/.config/moped/playlists"`
+	RandomOrder       string           `json:"random_order"`
+	ReplayGain        ReplayGainConfig `json:"replaygain"`
+	LoudnessCache     string           `json:"loudness_cache" default:"~/.cache/moped/loudness.json"`
+	Patterns          []string         `json:"patterns"`
+}
+
+// Returns the audio format that all playback should be converted to, as given in the
+// audio_output_format setting.  Parts of the format that are not specified (or given as "*")
+// are left unset, and will be negotiated from the song being played.
+func (self *Configuration) GetAudioFormat() (audio.Format, error) {
+	if self.AudioOutputFormat != `` {
+		return audio.ParseFormat(self.AudioOutputFormat)
+	}
+
+	return audio.Format{}, nil
+}
+
+// Returns the strategy used to shuffle the queue in random mode, as given in the random_order
+// setting.
+func (self *Configuration) GetRandomOrder() (library.EntryOrder, error) {
+	if order := library.EntryOrder(self.RandomOrder); order == library.OrderLinear || IsRandomOrder(order) {
+		return order, nil
+	} else {
+		return order, fmt.Errorf("Invalid random_order %q", self.RandomOrder)
+	}
+}
+
+// Returns the ReplayGain settings and the initial replay gain mode, as given in the replaygain
+// section.
+func (self *Configuration) GetReplayGain() (audio.ReplayGain, audio.ReplayGainMode, error) {
+	settings := audio.ReplayGain{
+		Preamp:        self.ReplayGain.Preamp,
+		MissingPreamp: self.ReplayGain.MissingPreamp,
+		Limit:         true,
+	}
+
+	if self.ReplayGain.Limit != nil {
+		settings.Limit = *self.ReplayGain.Limit
+	}
+
+	mode, err := audio.ParseReplayGainMode(self.ReplayGain.Mode)
+
+	return settings, mode, err
 }
 
 func LoadConfigFromFile(f string) (*Configuration, error) {
@@ -34,6 +97,14 @@ func LoadConfigFromFile(f string) (*Configuration, error) {
 
 			if data, err := ioutil.ReadAll(file); err == nil {
 				if err := yaml.Unmarshal(data, &config); err == nil {
+					defaults.SetDefaults(&config)
+
+					if cache, err := pathutil.ExpandUser(config.LoudnessCache); err == nil {
+						metadata.LoudnessCacheFile = cache
+					} else {
+						return nil, err
+					}
+
 					// initialize regexp patterns for the metadata package
 					for _, pattern := range config.Patterns {
 						if rx, err := regexp.Compile(pattern); err == nil {
@@ -63,31 +134,43 @@ func GetLibrariesFromConfig(config *Configuration) (map[string]library.Library,
 
 	if config != nil {
 		for i, libconfig := range config.Libraries {
-			var lib library.Library
-			var err error
-
 			if libconfig.Name == `` {
 				return nil, fmt.Errorf("Must specify a name for library %d", i)
 			}
 
-			switch libconfig.Type {
-			case `local`:
-				var cfg backends.FilesystemConfig
+			if lib, err := backends.New(libconfig.Type, libconfig.Configuration); err == nil {
+				libraries[libconfig.Name] = lib
+			} else {
+				return nil, fmt.Errorf("Error configuring library %d: %v", i, err)
+			}
+		}
+	}
 
-				if err := maputil.TaggedStructFromMap(libconfig.Configuration, &cfg, `json`); err != nil {
-					return nil, fmt.Errorf("Error configuring library %d: %v", i, err)
-				}
+	return libraries, nil
+}
+
+func GetOutputsFromConfig(config *Configuration) ([]*outputs.Device, error) {
+	devices := make([]*outputs.Device, 0)
 
-				lib, err = backends.NewFilesystemBackend(&cfg)
+	if config != nil {
+		for i, outconfig := range config.Outputs {
+			if outconfig.Name == `` {
+				return nil, fmt.Errorf("Must specify a name for output %d", i)
 			}
 
-			if err == nil {
-				libraries[libconfig.Name] = lib
+			if output, err := outputs.New(outconfig.Type, outconfig.Configuration); err == nil {
+				device := outputs.NewDevice(outconfig.Name, outconfig.Type, output)
+
+				if outconfig.Enabled != nil {
+					device.SetEnabled(*outconfig.Enabled)
+				}
+
+				devices = append(devices, device)
 			} else {
-				return nil, fmt.Errorf("Error configuring library %d: %v", i, err)
+				return nil, fmt.Errorf("Error configuring output %d: %v", i, err)
 			}
 		}
 	}
 
-	return libraries, nil
+	return devices, nil
 }
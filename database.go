@@ -0,0 +1,446 @@
+package moped
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/library"
+	bolt "go.etcd.io/bbolt"
+)
+
+var dbLibrariesBucket = []byte(`libraries`)
+var dbScannedBucket = []byte(`scanned`)
+
+// The Database is an on-disk copy of the entries (and metadata) of every library, so that
+// browsing and searching don't have to load metadata from the libraries themselves.  Each library
+// has a bucket of its own (in the "libraries" bucket), in which every folder is a nested bucket
+// (named after the folder, plus a trailing slash) holding the records of the entries in it.  When
+// each library was last updated is kept in the "scanned" bucket.
+type Database struct {
+	filename string
+	db       *bolt.DB
+}
+
+// what the database stores for each entry
+type dbRecord struct {
+	Info  library.EntryInfo `json:"info"`
+	Entry *library.Entry    `json:"entry"`
+}
+
+func OpenDatabase(filename string) (*Database, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filename, 0644, &bolt.Options{
+		Timeout: time.Second,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open database %v: %v", filename, err)
+	}
+
+	return &Database{
+		filename: filename,
+		db:       db,
+	}, nil
+}
+
+func (self *Database) Close() error {
+	return self.db.Close()
+}
+
+// Returns whether the given library has been scanned into the database in full.
+func (self *Database) HasLibrary(name string) bool {
+	return !self.LastScanned(name).IsZero()
+}
+
+// Returns when the given library was last updated, or a zero time if it was never scanned in full.
+func (self *Database) LastScanned(name string) time.Time {
+	var scanned time.Time
+
+	self.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(dbScannedBucket); bucket != nil {
+			if value := bucket.Get([]byte(name)); value != nil {
+				return scanned.UnmarshalText(value)
+			}
+		}
+
+		return nil
+	})
+
+	return scanned
+}
+
+// Removes everything the database knows about the given library.
+func (self *Database) Forget(name string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(dbScannedBucket); bucket != nil {
+			if err := bucket.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		if bucket := tx.Bucket(dbLibrariesBucket); bucket != nil {
+			deleteBucket(bucket, name)
+		}
+
+		return nil
+	})
+}
+
+// Brings the given folder of a library (and everything below it) up to date.  Libraries that are
+// Listers only have the metadata of new and changed entries loaded (unless rescan is set); all
+// others are re-read in full.  Returns the number of entries that were added, changed or removed.
+func (self *Database) Scan(name string, lib library.Library, folder string, rescan bool) (int, error) {
+	folder = strings.Trim(folder, `/`)
+	changed, err := self.scan(name, lib, folder, rescan)
+
+	if err != nil {
+		return changed, err
+	}
+
+	// a library counts as scanned once all of it has been; after that, updating any part of it
+	// counts as updating the library
+	if folder == `` || self.HasLibrary(name) {
+		err = self.db.Update(func(tx *bolt.Tx) error {
+			if bucket, err := tx.CreateBucketIfNotExists(dbScannedBucket); err == nil {
+				if value, err := time.Now().MarshalText(); err == nil {
+					return bucket.Put([]byte(name), value)
+				} else {
+					return err
+				}
+			} else {
+				return err
+			}
+		})
+	}
+
+	return changed, err
+}
+
+func (self *Database) scan(name string, lib library.Library, folder string, rescan bool) (int, error) {
+	existing, found, err := self.records(name, folder)
+
+	if err != nil {
+		return 0, err
+	}
+
+	updates := make(map[string]*dbRecord)
+	folders := make([]string, 0)
+
+	if lister, ok := lib.(library.Lister); ok {
+		infos, err := lister.List(folder)
+
+		if err != nil {
+			return 0, err
+		}
+
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name, `.`) {
+				continue
+			}
+
+			record, known := existing[info.Name]
+			delete(existing, info.Name)
+
+			if info.Folder {
+				folders = append(folders, path.Join(folder, info.Name))
+			}
+
+			if known && !rescan && record.Info.Unchanged(info) {
+				continue
+			}
+
+			if entry, err := lib.Get(path.Join(folder, info.Name)); err == nil {
+				self.analyze(name, lib, entry)
+
+				updates[info.Name] = &dbRecord{
+					Info:  info,
+					Entry: entry,
+				}
+			} else {
+				log.Warningf("Failed to read %v: %v", path.Join(name, folder, info.Name), err)
+			}
+		}
+	} else {
+		entries, err := lib.Browse(folder)
+
+		if err != nil {
+			return 0, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsHidden() {
+				continue
+			}
+
+			base := path.Base(entry.Path)
+			delete(existing, base)
+
+			if entry.Type == library.FolderEntry {
+				folders = append(folders, path.Join(folder, base))
+			}
+
+			self.analyze(name, lib, entry)
+
+			updates[base] = &dbRecord{
+				Info: library.EntryInfo{
+					Name:         base,
+					Folder:       (entry.Type == library.FolderEntry),
+					LastModified: entry.Metadata.LastModified,
+				},
+				Entry: entry,
+			}
+		}
+	}
+
+	if len(updates) > 0 || len(existing) > 0 || !found {
+		err := self.db.Update(func(tx *bolt.Tx) error {
+			bucket, err := self.createFolderBucket(tx, name, folder)
+
+			if err != nil {
+				return err
+			}
+
+			for base, record := range updates {
+				if data, err := json.Marshal(record); err == nil {
+					if err := bucket.Put([]byte(base), data); err != nil {
+						return err
+					}
+				} else {
+					return err
+				}
+
+				// this may have been a folder before
+				if !record.Info.Folder {
+					deleteBucket(bucket, base+`/`)
+				}
+			}
+
+			for base := range existing {
+				if err := bucket.Delete([]byte(base)); err != nil {
+					return err
+				}
+
+				deleteBucket(bucket, base+`/`)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	changed := len(updates) + len(existing)
+
+	for _, subfolder := range folders {
+		if n, err := self.scan(name, lib, subfolder, rescan); err == nil {
+			changed += n
+		} else {
+			log.Warningf("Failed to scan %v: %v", path.Join(name, subfolder), err)
+		}
+	}
+
+	return changed, nil
+}
+
+// adds the metadata that libraries only load while scanning (for those that are Analyzers)
+func (self *Database) analyze(name string, lib library.Library, entry *library.Entry) {
+	if analyzer, ok := lib.(library.Analyzer); ok {
+		if err := analyzer.Analyze(entry); err != nil {
+			log.Warningf("Failed to analyze %v: %v", path.Join(name, entry.Path), err)
+		}
+	}
+}
+
+// Returns the entries in the given folder of a library, or the entry itself if the path is that
+// of a file.
+func (self *Database) Browse(name string, entryPath string) (library.EntryList, error) {
+	entryPath = strings.Trim(entryPath, `/`)
+	entries := make(library.EntryList, 0)
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if bucket := self.folderBucket(tx, name, entryPath); bucket != nil {
+			return bucket.ForEach(func(key []byte, value []byte) error {
+				if value == nil {
+					return nil
+				}
+
+				if record, err := decodeRecord(value); err == nil {
+					entries = append(entries, record.Entry)
+					return nil
+				} else {
+					return err
+				}
+			})
+		} else if parent := self.folderBucket(tx, name, dirname(entryPath)); parent != nil {
+			if value := parent.Get([]byte(path.Base(entryPath))); value != nil {
+				if record, err := decodeRecord(value); err == nil {
+					entries = append(entries, record.Entry)
+					return nil
+				} else {
+					return err
+				}
+			}
+		}
+
+		return fmt.Errorf("No such file or directory: %v", path.Join(name, entryPath))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entry.SetParentPath(name)
+	}
+
+	return entries, nil
+}
+
+// Returns all of the songs in the given libraries.
+func (self *Database) Entries(names ...string) (library.EntryList, error) {
+	entries := make(library.EntryList, 0)
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if bucket := self.folderBucket(tx, name, ``); bucket != nil {
+				if err := collectEntries(bucket, name, &entries); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+func collectEntries(bucket *bolt.Bucket, name string, entries *library.EntryList) error {
+	return bucket.ForEach(func(key []byte, value []byte) error {
+		if value == nil {
+			if sub := bucket.Bucket(key); sub != nil {
+				return collectEntries(sub, name, entries)
+			}
+
+			return nil
+		}
+
+		if record, err := decodeRecord(value); err == nil {
+			if record.Entry.IsContent() {
+				record.Entry.SetParentPath(name)
+				*entries = append(*entries, record.Entry)
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	})
+}
+
+// reads the records of the entries in the given folder of a library, and whether the folder is
+// in the database at all
+func (self *Database) records(name string, folder string) (map[string]*dbRecord, bool, error) {
+	records := make(map[string]*dbRecord)
+	var found bool
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if bucket := self.folderBucket(tx, name, folder); bucket != nil {
+			found = true
+
+			return bucket.ForEach(func(key []byte, value []byte) error {
+				if value != nil {
+					if record, err := decodeRecord(value); err == nil {
+						records[string(key)] = record
+					} else {
+						return err
+					}
+				}
+
+				return nil
+			})
+		}
+
+		return nil
+	})
+
+	return records, found, err
+}
+
+func (self *Database) folderBucket(tx *bolt.Tx, name string, folder string) *bolt.Bucket {
+	bucket := tx.Bucket(dbLibrariesBucket)
+
+	if bucket != nil {
+		bucket = bucket.Bucket([]byte(name))
+	}
+
+	if folder != `` {
+		for _, part := range strings.Split(folder, `/`) {
+			if bucket == nil {
+				break
+			}
+
+			bucket = bucket.Bucket([]byte(part + `/`))
+		}
+	}
+
+	return bucket
+}
+
+func (self *Database) createFolderBucket(tx *bolt.Tx, name string, folder string) (*bolt.Bucket, error) {
+	bucket, err := tx.CreateBucketIfNotExists(dbLibrariesBucket)
+
+	if err == nil {
+		bucket, err = bucket.CreateBucketIfNotExists([]byte(name))
+	}
+
+	if err == nil && folder != `` {
+		for _, part := range strings.Split(folder, `/`) {
+			if bucket, err = bucket.CreateBucketIfNotExists([]byte(part + `/`)); err != nil {
+				break
+			}
+		}
+	}
+
+	return bucket, err
+}
+
+func deleteBucket(bucket *bolt.Bucket, key string) {
+	if err := bucket.DeleteBucket([]byte(key)); err != nil && err != bolt.ErrBucketNotFound {
+		log.Warningf("Failed to remove %v from the database: %v", key, err)
+	}
+}
+
+func decodeRecord(data []byte) (*dbRecord, error) {
+	var record dbRecord
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	if record.Entry == nil {
+		return nil, fmt.Errorf("Invalid database record")
+	}
+
+	return &record, nil
+}
+
+// like path.Dir, but the top level is an empty string rather than "."
+func dirname(entryPath string) string {
+	if dir := path.Dir(entryPath); dir != `.` {
+		return dir
+	}
+
+	return ``
+}
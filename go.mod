@@ -3,12 +3,15 @@ module github.com/ghetzel/moped
 require (
 	github.com/dhowden/tag v0.0.0-20191122115059-7e5c04feccd8
 	github.com/fatih/structs v1.1.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/ghetzel/cli v1.17.0
 	github.com/ghetzel/go-stockutil v1.7.1
 	github.com/ghodss/yaml v1.0.0
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
 	github.com/mcuadros/go-defaults v1.1.0
 	github.com/spaolacci/murmur3 v0.0.0-20180118202830-f09979ecbc72
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/sys v0.10.0 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 	launchpad.net/gocheck v0.0.0-20140225173054-000000000087 // indirect
 )
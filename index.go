@@ -0,0 +1,205 @@
+package moped
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/library"
+)
+
+// The Index is a list of all of the songs in every library, which searches and tag listings are
+// served from so that they don't have to walk the libraries every time.  It is built when it is
+// first needed, and rebuilt after being invalidated (e.g.: because a library was added).
+type Index struct {
+	app     *Moped
+	entries library.EntryList
+	built   bool
+	updated time.Time
+	lock    sync.RWMutex
+	scan    sync.Mutex
+}
+
+func NewIndex(app *Moped) *Index {
+	return &Index{
+		app: app,
+	}
+}
+
+// Returns all of the songs in the index, building it first if necessary.
+func (self *Index) Entries() (library.EntryList, error) {
+	self.lock.RLock()
+	entries := self.entries
+	built := self.built
+	self.lock.RUnlock()
+
+	if built {
+		return entries, nil
+	}
+
+	if err := self.build(); err != nil {
+		return nil, err
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.entries, nil
+}
+
+// Returns all of the songs in the index that match the given filter.
+func (self *Index) Search(filter library.Filter) (library.EntryList, error) {
+	entries, err := self.Entries()
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(library.EntryList, 0)
+
+	for _, entry := range entries {
+		if filter(entry) {
+			results = append(results, entry)
+		}
+	}
+
+	return results, nil
+}
+
+// Returns when the index was last built.
+func (self *Index) Updated() time.Time {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.updated
+}
+
+// Marks the index as out of date, so that it is rebuilt the next time it is used.
+func (self *Index) Invalidate() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.built = false
+}
+
+// Brings the given paths (or every library, if a path is empty) up to date in the database and
+// reloads the index.  Unless rescan is set, only entries that have changed since they were last
+// scanned are re-read.  Returns the number of entries that changed (which, without a database, is
+// not known and always reported as one).
+func (self *Index) Update(rescan bool, entryPaths ...string) (int, error) {
+	self.scan.Lock()
+	defer self.scan.Unlock()
+
+	changed := 1
+
+	if db := self.app.db; db != nil {
+		changed = 0
+
+		for _, entryPath := range entryPaths {
+			if name, rest, lib, ok := self.app.GetLibraryForPath(entryPath); ok {
+				// files are updated by scanning the folder they're in
+				if entry, err := lib.Get(rest); err == nil && entry.Type != library.FolderEntry {
+					rest = dirname(strings.Trim(rest, `/`))
+				}
+
+				if n, err := db.Scan(name, lib, rest, rescan); err == nil {
+					changed += n
+				} else {
+					return changed, err
+				}
+			} else if name == `` {
+				for _, name := range self.app.LibraryNames() {
+					lib, ok := self.app.Library(name)
+
+					if !ok {
+						continue
+					}
+
+					if n, err := db.Scan(name, lib, ``, rescan); err == nil {
+						changed += n
+					} else {
+						return changed, err
+					}
+				}
+			} else {
+				return changed, fmt.Errorf("No such library '%v'", name)
+			}
+		}
+	}
+
+	return changed, self.load()
+}
+
+func (self *Index) build() error {
+	// only one scan runs at a time, and anyone who was waiting on it uses its results
+	self.scan.Lock()
+	defer self.scan.Unlock()
+
+	self.lock.RLock()
+	built := self.built
+	self.lock.RUnlock()
+
+	if built {
+		return nil
+	}
+
+	// libraries that have never been scanned into the database are scanned now
+	if db := self.app.db; db != nil {
+		for _, name := range self.app.LibraryNames() {
+			if lib, ok := self.app.Library(name); ok && !db.HasLibrary(name) {
+				if changed, err := db.Scan(name, lib, ``, false); err == nil {
+					log.Infof("Scanned library %v: %d entries changed", name, changed)
+				} else {
+					return err
+				}
+			}
+		}
+	}
+
+	return self.load()
+}
+
+// (re)loads the index from the database, or by walking every library if there isn't one
+func (self *Index) load() error {
+	var entries library.EntryList
+	var err error
+
+	started := time.Now()
+
+	if db := self.app.db; db != nil {
+		entries, err = db.Entries(self.app.LibraryNames()...)
+	} else {
+		entries, err = self.walk()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.entries = entries
+	self.built = true
+	self.updated = time.Now()
+
+	log.Debugf("Indexed %d songs in %v", len(entries), time.Since(started))
+	return nil
+}
+
+// Collects the songs by walking every library.
+func (self *Index) walk() (library.EntryList, error) {
+	entries := make(library.EntryList, 0)
+
+	err := self.app.Walk(``, func(entry *library.Entry) error {
+		if entry.IsContent() {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
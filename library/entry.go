@@ -29,14 +29,23 @@ const (
 
 type EntryID uint32
 
+// The part of a file that an entry consists of, for entries that aren't a whole file (e.g.: the
+// tracks of a cue sheet).  A zero End means the entry runs until the end of the file.
+type Segment struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end,omitempty"`
+}
+
 type Entry struct {
 	Path            string    `json:"path"`
 	Filename        string    `json:"-"`
 	Type            EntryType `json:"type,omitempty"`
 	Metadata        Metadata  `json:"metadata"`
+	Segment         *Segment  `json:"segment,omitempty"`
 	mimeOverride    string
 	sortKeyOverride string
 	source          io.ReadCloser
+	localPath       string
 	parent          string
 }
 
@@ -61,6 +70,11 @@ func (self *Entry) FileRetrievalPath() string {
 }
 
 func (self *Entry) FullPath() string {
+	// remote entries (e.g.: streams listed in a playlist) are always referred to by their URL
+	if self.IsRemote() {
+		return self.Path
+	}
+
 	return strings.TrimPrefix(path.Join(`/`, self.parent, self.Path), `/`)
 }
 
@@ -105,6 +119,19 @@ func (self *Entry) IsHidden() bool {
 	}
 }
 
+// Returns an entry for a URL (e.g.: an internet radio stream) rather than something in a library.
+func NewRemoteEntry(location string) *Entry {
+	return &Entry{
+		Path: location,
+		Type: AudioEntry,
+	}
+}
+
+// Returns whether the entry is a URL rather than something in a library.
+func (self *Entry) IsRemote() bool {
+	return IsRemoteLocation(self.Path)
+}
+
 func (self *Entry) IsContainer() bool {
 	switch self.Type {
 	case FolderEntry, PlaylistEntry:
@@ -172,6 +199,57 @@ func (self *Entry) Get(field string) typeutil.Variant {
 	}
 }
 
+// Returns the value of the given MPD tag (e.g.: "Artist", "Date", "file"), or an empty string if
+// the entry doesn't have it.  Tag names are case-insensitive.
+func (self *Entry) Tag(name string) string {
+	switch tag := strings.ToLower(name); tag {
+	case `file`:
+		return self.FullPath()
+	case `title`, `artist`, `album`, `genre`, `track`, `disc`:
+		if v := self.Get(strings.Title(tag)); !v.IsNil() {
+			return v.String()
+		}
+	case `date`:
+		if self.Metadata.Year > 0 {
+			return fmt.Sprintf("%d", self.Metadata.Year)
+		}
+	case `last-modified`:
+		if !self.Metadata.LastModified.IsZero() {
+			return self.Metadata.LastModified.UTC().Format(time.RFC3339)
+		}
+	case `added`:
+		if added := self.Added(); !added.IsZero() {
+			return added.UTC().Format(time.RFC3339)
+		}
+	case `audioformat`:
+		format := []string{`*`, `*`, `*`}
+
+		for i, key := range []string{`samplerate`, `bits`, `channels`} {
+			if v := typeutil.Int(self.Metadata.Extra[key]); v > 0 {
+				format[i] = fmt.Sprintf("%d", v)
+			}
+		}
+
+		return strings.Join(format, `:`)
+	default:
+		if v, ok := self.Metadata.Extra[tag]; ok {
+			return typeutil.String(v)
+		}
+	}
+
+	return ``
+}
+
+// Returns when the entry was added to the library, which (if that isn't known) is assumed to be
+// when it was last modified.
+func (self *Entry) Added() time.Time {
+	if self.Metadata.Added.IsZero() {
+		return self.Metadata.LastModified
+	}
+
+	return self.Metadata.Added
+}
+
 func (self *Entry) MimeType() string {
 	if self.mimeOverride != `` {
 		return self.mimeOverride
@@ -190,6 +268,17 @@ func (self *Entry) SetSource(rc io.ReadCloser) {
 	self.source = rc
 }
 
+// Records the local file that the entry's data is read from, so that it can be opened directly by
+// things that need to seek around in it (such as the decoder).
+func (self *Entry) SetLocalPath(filename string) {
+	self.localPath = filename
+}
+
+// Returns the local file that the entry's data is read from, if there is one.
+func (self *Entry) LocalPath() (string, bool) {
+	return self.localPath, (self.localPath != ``)
+}
+
 func (self *Entry) Read(b []byte) (int, error) {
 	if self.source == nil {
 		return 0, fmt.Errorf("Entry datasource not set")
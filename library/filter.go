@@ -0,0 +1,537 @@
+package library
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A Filter decides whether an entry is included in the results of a search.
+type Filter func(entry *Entry) bool
+
+// The tags that are checked when filtering on the "any" pseudo-tag.
+var AnyTags = []string{
+	`Artist`,
+	`AlbumArtist`,
+	`Album`,
+	`Title`,
+	`Genre`,
+	`Date`,
+	`Composer`,
+	`Performer`,
+	`Comment`,
+}
+
+// The names of the tags that entries can be searched by, as MPD spells them.
+var TagNames = []string{
+	`Artist`,
+	`ArtistSort`,
+	`Album`,
+	`AlbumSort`,
+	`AlbumArtist`,
+	`AlbumArtistSort`,
+	`Title`,
+	`Track`,
+	`Name`,
+	`Genre`,
+	`Date`,
+	`Composer`,
+	`Performer`,
+	`Comment`,
+	`Disc`,
+}
+
+// Returns the given tag name as MPD spells it (e.g.: "albumartist" becomes "AlbumArtist").
+func TagName(name string) string {
+	for _, tag := range TagNames {
+		if strings.EqualFold(tag, name) {
+			return tag
+		}
+	}
+
+	return name
+}
+
+// Returns whether entries can be filtered by the given tag: any of the TagNames, or one of the
+// pseudo-tags "file", "any" and "AudioFormat".
+func IsFilterTag(name string) bool {
+	switch strings.ToLower(name) {
+	case `file`, `any`, `audioformat`:
+		return true
+	}
+
+	for _, tag := range TagNames {
+		if strings.EqualFold(tag, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches every entry.
+func MatchAll(entry *Entry) bool {
+	return true
+}
+
+// Parses the filter arguments of a search command, which are filter expressions such as
+// "((Artist == 'x') AND (Album != 'y'))", legacy pairs of tag names and values ("Artist x"), or a
+// mix of the two; all of them must match for an entry to be included.  If foldCase is set, all
+// comparisons are case-insensitive and legacy values match anywhere in a tag (as with "search"),
+// otherwise they have to match the whole tag (as with "find").
+func ParseFilter(args []string, foldCase bool) (Filter, error) {
+	filters := make([]Filter, 0)
+
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], `(`) {
+			parser := &filterParser{
+				input:    args[i],
+				foldCase: foldCase,
+			}
+
+			if filter, err := parser.Parse(); err == nil {
+				filters = append(filters, filter)
+			} else {
+				return nil, err
+			}
+		} else if i+1 < len(args) {
+			if filter, err := legacyFilter(args[i], args[i+1], foldCase); err == nil {
+				filters = append(filters, filter)
+				i++
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("Missing value for filter tag %q", args[i])
+		}
+	}
+
+	return allOf(filters), nil
+}
+
+func legacyFilter(tag string, value string, foldCase bool) (Filter, error) {
+	switch strings.ToLower(tag) {
+	case `base`:
+		return baseFilter(value), nil
+	case `modified-since`, `added-since`:
+		return sinceFilter(tag, value)
+	default:
+		op := `==`
+
+		if foldCase {
+			op = `contains`
+		}
+
+		return tagFilter(tag, op, value, foldCase)
+	}
+}
+
+// a recursive descent parser for MPD filter expressions
+type filterParser struct {
+	input    string
+	pos      int
+	foldCase bool
+}
+
+func (self *filterParser) Parse() (Filter, error) {
+	filter, err := self.expression()
+
+	if err == nil {
+		if self.skipSpace(); self.pos < len(self.input) {
+			return nil, self.errorf("unexpected %q after the end of the expression", self.input[self.pos:])
+		}
+	}
+
+	return filter, err
+}
+
+func (self *filterParser) expression() (Filter, error) {
+	if err := self.expect(`(`); err != nil {
+		return nil, err
+	}
+
+	self.skipSpace()
+
+	switch {
+	case self.peek() == '(':
+		// either a parenthesized expression or a conjunction of several of them
+		filters := make([]Filter, 0)
+
+		for {
+			if filter, err := self.expression(); err == nil {
+				filters = append(filters, filter)
+			} else {
+				return nil, err
+			}
+
+			self.skipSpace()
+
+			if self.peek() == ')' {
+				self.pos++
+				return allOf(filters), nil
+			} else if word := self.word(); word != `AND` {
+				return nil, self.errorf("expected %q or %q", `AND`, `)`)
+			}
+
+			self.skipSpace()
+		}
+
+	case self.peek() == '!' && !strings.HasPrefix(self.input[self.pos:], `!=`):
+		self.pos++
+		return self.negation()
+
+	case strings.HasPrefix(self.input[self.pos:], `NOT `):
+		self.pos += 3
+		return self.negation()
+	}
+
+	var filter Filter
+	var err error
+
+	tag := self.word()
+
+	if tag == `` {
+		return nil, self.errorf("expected a tag name")
+	}
+
+	switch strings.ToLower(tag) {
+	case `base`:
+		var value string
+
+		if value, err = self.quoted(); err == nil {
+			filter = baseFilter(value)
+		}
+
+	case `modified-since`, `added-since`:
+		var value string
+
+		if value, err = self.quoted(); err == nil {
+			filter, err = sinceFilter(tag, value)
+		}
+
+	default:
+		if !IsFilterTag(tag) {
+			return nil, self.errorf("unknown tag %q", tag)
+		}
+
+		op := self.operator()
+
+		if op == `` {
+			return nil, self.errorf("expected an operator after %q", tag)
+		}
+
+		var value string
+
+		if value, err = self.quoted(); err == nil {
+			filter, err = tagFilter(tag, op, value, self.foldCase)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := self.expect(`)`); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (self *filterParser) negation() (Filter, error) {
+	self.skipSpace()
+
+	if filter, err := self.expression(); err == nil {
+		if err := self.expect(`)`); err != nil {
+			return nil, err
+		}
+
+		return func(entry *Entry) bool {
+			return !filter(entry)
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *filterParser) peek() byte {
+	if self.pos < len(self.input) {
+		return self.input[self.pos]
+	}
+
+	return 0
+}
+
+func (self *filterParser) skipSpace() {
+	for self.pos < len(self.input) && unicode.IsSpace(rune(self.input[self.pos])) {
+		self.pos++
+	}
+}
+
+func (self *filterParser) expect(token string) error {
+	self.skipSpace()
+
+	if strings.HasPrefix(self.input[self.pos:], token) {
+		self.pos += len(token)
+		return nil
+	}
+
+	return self.errorf("expected %q", token)
+}
+
+// reads a tag name or a keyword
+func (self *filterParser) word() string {
+	start := self.pos
+
+	for self.pos < len(self.input) {
+		if c := rune(self.input[self.pos]); unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' {
+			self.pos++
+		} else {
+			break
+		}
+	}
+
+	return self.input[start:self.pos]
+}
+
+// reads a comparison operator, which is either symbolic (==, !=, =~, !~) or a keyword (contains)
+func (self *filterParser) operator() string {
+	self.skipSpace()
+
+	for _, op := range []string{`==`, `!=`, `=~`, `!~`} {
+		if strings.HasPrefix(self.input[self.pos:], op) {
+			self.pos += len(op)
+			return op
+		}
+	}
+
+	// negated keyword operators (!contains, !starts_with)
+	if self.peek() == '!' {
+		self.pos++
+		return `!` + self.word()
+	}
+
+	return self.word()
+}
+
+// reads a single- or double-quoted string, in which a backslash escapes the character after it
+func (self *filterParser) quoted() (string, error) {
+	self.skipSpace()
+
+	quote := self.peek()
+
+	if quote != '\'' && quote != '"' {
+		return ``, self.errorf("expected a quoted value")
+	}
+
+	var value strings.Builder
+
+	for self.pos++; self.pos < len(self.input); self.pos++ {
+		switch c := self.input[self.pos]; c {
+		case '\\':
+			if self.pos++; self.pos < len(self.input) {
+				value.WriteByte(self.input[self.pos])
+			}
+		case quote:
+			self.pos++
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return ``, self.errorf("unterminated quoted value")
+}
+
+func (self *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid filter expression %q at position %d: %v", self.input, self.pos, fmt.Sprintf(format, args...))
+}
+
+func allOf(filters []Filter) Filter {
+	switch len(filters) {
+	case 0:
+		return MatchAll
+	case 1:
+		return filters[0]
+	default:
+		return func(entry *Entry) bool {
+			for _, filter := range filters {
+				if !filter(entry) {
+					return false
+				}
+			}
+
+			return true
+		}
+	}
+}
+
+// matches entries at or below the given path
+func baseFilter(base string) Filter {
+	base = strings.Trim(base, `/`)
+
+	return func(entry *Entry) bool {
+		if base == `` {
+			return true
+		}
+
+		p := entry.FullPath()
+		return (p == base || strings.HasPrefix(p, base+`/`))
+	}
+}
+
+func sinceFilter(tag string, value string) (Filter, error) {
+	since, err := parseFilterTime(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(tag, `added-since`) {
+		return func(entry *Entry) bool {
+			return !entry.Added().Before(since)
+		}, nil
+	} else {
+		return func(entry *Entry) bool {
+			return !entry.Metadata.LastModified.Before(since)
+		}, nil
+	}
+}
+
+// timestamps are given either as seconds since the epoch or in ISO 8601 format
+func parseFilterTime(value string) (time.Time, error) {
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(v, 0), nil
+	}
+
+	for _, layout := range []string{
+		time.RFC3339,
+		`2006-01-02T15:04:05`,
+		`2006-01-02`,
+	} {
+		if tm, err := time.Parse(layout, value); err == nil {
+			return tm, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid timestamp %q", value)
+}
+
+func tagFilter(tag string, op string, value string, foldCase bool) (Filter, error) {
+	var match func(string) bool
+
+	if !IsFilterTag(tag) {
+		return nil, fmt.Errorf("Unknown tag %q", tag)
+	}
+
+	// the _cs and _ci variants of the operators override the case sensitivity of the command
+	if strings.HasSuffix(op, `_cs`) {
+		op = strings.TrimSuffix(op, `_cs`)
+		foldCase = false
+	} else if strings.HasSuffix(op, `_ci`) {
+		op = strings.TrimSuffix(op, `_ci`)
+		foldCase = true
+	}
+
+	if strings.EqualFold(tag, `AudioFormat`) {
+		return audioFormatFilter(op, value)
+	}
+
+	fold := func(s string) string {
+		if foldCase {
+			return strings.ToLower(s)
+		}
+
+		return s
+	}
+
+	switch op {
+	case `==`, `!=`, `eq`:
+		match = func(v string) bool {
+			return fold(v) == fold(value)
+		}
+	case `contains`, `!contains`:
+		match = func(v string) bool {
+			return strings.Contains(fold(v), fold(value))
+		}
+	case `starts_with`, `!starts_with`:
+		match = func(v string) bool {
+			return strings.HasPrefix(fold(v), fold(value))
+		}
+	case `=~`, `!~`:
+		pattern := value
+
+		if foldCase {
+			pattern = `(?i)` + pattern
+		}
+
+		if rx, err := regexp.Compile(pattern); err == nil {
+			match = rx.MatchString
+		} else {
+			return nil, fmt.Errorf("Invalid regular expression %q: %v", value, err)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown filter operator %q", op)
+	}
+
+	if strings.HasPrefix(op, `!`) {
+		positive := match
+
+		match = func(v string) bool {
+			return !positive(v)
+		}
+	}
+
+	if strings.EqualFold(tag, `any`) {
+		return func(entry *Entry) bool {
+			for _, t := range AnyTags {
+				if v := entry.Tag(t); v != `` && match(v) {
+					return true
+				}
+			}
+
+			return false
+		}, nil
+	}
+
+	return func(entry *Entry) bool {
+		return match(entry.Tag(tag))
+	}, nil
+}
+
+// Audio formats are compared field by field ("samplerate:bits:channels").  With =~, a field of
+// the value may be "*" to match anything.  Fields that aren't known for an entry match anything.
+func audioFormatFilter(op string, value string) (Filter, error) {
+	want := strings.Split(value, `:`)
+
+	if len(want) != 3 {
+		return nil, fmt.Errorf("Invalid audio format %q: expected samplerate:bits:channels", value)
+	}
+
+	switch op {
+	case `==`, `=~`:
+	default:
+		return nil, fmt.Errorf("Unsupported operator %q for AudioFormat", op)
+	}
+
+	return func(entry *Entry) bool {
+		have := strings.Split(entry.Tag(`AudioFormat`), `:`)
+
+		if len(have) != 3 {
+			return false
+		}
+
+		for i := range want {
+			if have[i] == `*` || (op == `=~` && want[i] == `*`) {
+				continue
+			} else if have[i] != want[i] {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
@@ -0,0 +1,284 @@
+package library
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var filterEntries = []*Entry{
+	{
+		Path: `alpha/first/one.flac`,
+		Metadata: Metadata{
+			Title:  `One`,
+			Artist: `Alpha`,
+			Album:  `First`,
+			Genre:  `Rock`,
+			Track:  1,
+		},
+	}, {
+		Path: `alpha/first/two.flac`,
+		Metadata: Metadata{
+			Title:  `It's Two`,
+			Artist: `Alpha`,
+			Album:  `First`,
+			Genre:  `Jazz`,
+			Track:  2,
+		},
+	}, {
+		Path: `beta/second/three.flac`,
+		Metadata: Metadata{
+			Title:  `Say "Three"`,
+			Artist: `Beta`,
+			Album:  `Second`,
+			Genre:  `Rock`,
+			Track:  1,
+		},
+	}, {
+		Path: `beta/second/four.flac`,
+		Metadata: Metadata{
+			Title:  `Back\Slash`,
+			Artist: `Alphabet`,
+			Album:  `Second`,
+			Track:  2,
+		},
+	},
+}
+
+// returns the titles of the entries that the given filter arguments match
+func filterTitles(t *testing.T, args []string, foldCase bool) []string {
+	filter, err := ParseFilter(args, foldCase)
+
+	if err != nil {
+		t.Fatalf("ParseFilter(%q) failed: %v", args, err)
+	}
+
+	titles := make([]string, 0)
+
+	for _, entry := range filterEntries {
+		if filter(entry) {
+			titles = append(titles, entry.Metadata.Title)
+		}
+	}
+
+	return titles
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		foldCase bool
+		want     []string
+	}{
+		{
+			name: `equals`,
+			args: []string{`(Artist == 'Alpha')`},
+			want: []string{`One`, `It's Two`},
+		}, {
+			name: `not equals`,
+			args: []string{`(Artist != 'Alpha')`},
+			want: []string{`Say "Three"`, `Back\Slash`},
+		}, {
+			name: `tag names are case-insensitive`,
+			args: []string{`(artist == 'Beta')`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `values are case-sensitive for find`,
+			args: []string{`(Artist == 'alpha')`},
+			want: []string{},
+		}, {
+			name:     `values are case-insensitive for search`,
+			args:     []string{`(Artist == 'alpha')`},
+			foldCase: true,
+			want:     []string{`One`, `It's Two`},
+		}, {
+			name: `AND`,
+			args: []string{`((Album == 'Second') AND (Track == '2'))`},
+			want: []string{`Back\Slash`},
+		}, {
+			name: `AND of three`,
+			args: []string{`((Genre == 'Rock') AND (Track == '1') AND (Album == 'First'))`},
+			want: []string{`One`},
+		}, {
+			name: `negation`,
+			args: []string{`(!(Genre == 'Rock'))`},
+			want: []string{`It's Two`, `Back\Slash`},
+		}, {
+			name: `negation with NOT`,
+			args: []string{`(NOT (Genre == 'Rock'))`},
+			want: []string{`It's Two`, `Back\Slash`},
+		}, {
+			name: `negation applies to the whole conjunction`,
+			args: []string{`(!((Genre == 'Rock') AND (Track == '1')))`},
+			want: []string{`It's Two`, `Back\Slash`},
+		}, {
+			name: `negation inside a conjunction only applies to its own expression`,
+			args: []string{`((!(Genre == 'Rock')) AND (Track == '2'))`},
+			want: []string{`It's Two`, `Back\Slash`},
+		}, {
+			name: `double negation`,
+			args: []string{`(!(!(Artist == 'Beta')))`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `separate expressions must all match`,
+			args: []string{`(Artist == 'Alpha')`, `(Track == '2')`},
+			want: []string{`It's Two`},
+		}, {
+			name: `escaped single quote`,
+			args: []string{`(Title == 'It\'s Two')`},
+			want: []string{`It's Two`},
+		}, {
+			name: `escaped double quotes`,
+			args: []string{`(Title == "Say \"Three\"")`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `escaped backslash`,
+			args: []string{`(Title == 'Back\\Slash')`},
+			want: []string{`Back\Slash`},
+		}, {
+			name: `regular expression`,
+			args: []string{`(Title =~ '^(One|Back)')`},
+			want: []string{`One`, `Back\Slash`},
+		}, {
+			name: `negated regular expression`,
+			args: []string{`(Title !~ '^(One|Back)')`},
+			want: []string{`It's Two`, `Say "Three"`},
+		}, {
+			name:     `regular expression for search`,
+			args:     []string{`(Title =~ '^one$')`},
+			foldCase: true,
+			want:     []string{`One`},
+		}, {
+			name: `contains`,
+			args: []string{`(Artist contains 'lph')`},
+			want: []string{`One`, `It's Two`, `Back\Slash`},
+		}, {
+			name: `negated contains`,
+			args: []string{`(Artist !contains 'lph')`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `starts_with`,
+			args: []string{`(Artist starts_with 'Alpha')`},
+			want: []string{`One`, `It's Two`, `Back\Slash`},
+		}, {
+			name: `negated starts_with`,
+			args: []string{`(Artist !starts_with 'Alpha')`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `case-insensitive operator`,
+			args: []string{`(Artist starts_with_ci 'alphab')`},
+			want: []string{`Back\Slash`},
+		}, {
+			name:     `case-sensitive operator`,
+			args:     []string{`(Artist contains_cs 'alpha')`},
+			foldCase: true,
+			want:     []string{},
+		}, {
+			name: `any tag`,
+			args: []string{`(any == 'Jazz')`},
+			want: []string{`It's Two`},
+		}, {
+			name: `file`,
+			args: []string{`(file starts_with 'beta/')`},
+			want: []string{`Say "Three"`, `Back\Slash`},
+		}, {
+			name: `base`,
+			args: []string{`(base 'alpha')`},
+			want: []string{`One`, `It's Two`},
+		}, {
+			name: `legacy pair`,
+			args: []string{`Album`, `Second`},
+			want: []string{`Say "Three"`, `Back\Slash`},
+		}, {
+			name:     `legacy pair for search`,
+			args:     []string{`album`, `sec`},
+			foldCase: true,
+			want:     []string{`Say "Three"`, `Back\Slash`},
+		}, {
+			name: `legacy pair and expression`,
+			args: []string{`Album`, `Second`, `(Track == '1')`},
+			want: []string{`Say "Three"`},
+		}, {
+			name: `no arguments`,
+			args: []string{},
+			want: []string{`One`, `It's Two`, `Say "Three"`, `Back\Slash`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterTitles(t, tt.args, tt.foldCase); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter(%q) matched %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: `unknown tag`,
+			args: []string{`(Bogus == 'x')`},
+			want: `unknown tag "Bogus"`,
+		}, {
+			name: `unknown tag in a conjunction`,
+			args: []string{`((Artist == 'Alpha') AND (Bogus == 'x'))`},
+			want: `unknown tag "Bogus"`,
+		}, {
+			name: `unknown tag in a legacy pair`,
+			args: []string{`Bogus`, `x`},
+			want: `Unknown tag "Bogus"`,
+		}, {
+			name: `unknown operator`,
+			args: []string{`(Artist like 'x')`},
+			want: `Unknown filter operator "like"`,
+		}, {
+			name: `missing operator`,
+			args: []string{`(Artist 'x')`},
+			want: `expected an operator`,
+		}, {
+			name: `unquoted value`,
+			args: []string{`(Artist == x)`},
+			want: `expected a quoted value`,
+		}, {
+			name: `unterminated value`,
+			args: []string{`(Artist == 'x)`},
+			want: `unterminated quoted value`,
+		}, {
+			name: `OR is not supported`,
+			args: []string{`((Artist == 'a') OR (Artist == 'b'))`},
+			want: `expected "AND" or ")"`,
+		}, {
+			name: `unbalanced parentheses`,
+			args: []string{`((Artist == 'a')`},
+			want: `expected "AND" or ")"`,
+		}, {
+			name: `trailing input`,
+			args: []string{`(Artist == 'a') x`},
+			want: `after the end of the expression`,
+		}, {
+			name: `invalid regular expression`,
+			args: []string{`(Title =~ '(')`},
+			want: `Invalid regular expression`,
+		}, {
+			name: `missing legacy value`,
+			args: []string{`Artist`},
+			want: `Missing value for filter tag "Artist"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFilter(tt.args, false); err == nil {
+				t.Errorf("ParseFilter(%q) succeeded, want an error containing %q", tt.args, tt.want)
+			} else if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseFilter(%q) failed with %q, want an error containing %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
@@ -19,16 +19,8 @@ func NewLazyReader(opener LazyOpener) *LazyReader {
 }
 
 func (self *LazyReader) Read(b []byte) (int, error) {
-	if self.readCloser == nil {
-		if self.Opener != nil {
-			if rc, err := self.Opener(); err == nil {
-				self.readCloser = rc
-			} else {
-				return 0, err
-			}
-		} else {
-			return 0, fmt.Errorf("No opener specified")
-		}
+	if err := self.open(); err != nil {
+		return 0, err
 	}
 
 	return self.readCloser.Read(b)
@@ -43,9 +35,29 @@ func (self *LazyReader) Close() error {
 }
 
 func (self *LazyReader) Seek(offset int64, whence int) (int64, error) {
+	if err := self.open(); err != nil {
+		return 0, err
+	}
+
 	if seeker, ok := self.readCloser.(io.Seeker); ok {
 		return seeker.Seek(offset, whence)
 	} else {
 		return 0, fmt.Errorf("source not seekable")
 	}
 }
+
+func (self *LazyReader) open() error {
+	if self.readCloser == nil {
+		if self.Opener != nil {
+			if rc, err := self.Opener(); err == nil {
+				self.readCloser = rc
+			} else {
+				return err
+			}
+		} else {
+			return fmt.Errorf("No opener specified")
+		}
+	}
+
+	return nil
+}
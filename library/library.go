@@ -1,7 +1,53 @@
 package library
 
+import (
+	"time"
+)
+
 type Library interface {
 	Ping() error
 	Browse(string) (EntryList, error)
 	Get(string) (*Entry, error)
 }
+
+// A Lister is a Library that can cheaply list the contents of a folder without loading any
+// metadata, which lets the database tell which entries have changed since they were last scanned.
+type Lister interface {
+	List(string) ([]EntryInfo, error)
+}
+
+// A Watcher is a Library that can tell when its contents change.  Once watching has started, the
+// given function is called with the paths of the folders whose contents have changed.
+type Watcher interface {
+	Watch(func(folders []string)) error
+}
+
+// A Closer is a Library that holds on to resources (such as filesystem watches) which have to be
+// released once it's no longer used.
+type Closer interface {
+	Close() error
+}
+
+// An Analyzer is a Library that can add metadata to its entries which is too expensive to load
+// while browsing (such as loudness measurements).  The database analyzes entries as it scans them.
+type Analyzer interface {
+	Analyze(*Entry) error
+}
+
+// A Locator is a Library that can tell where its contents are kept, as a URI.
+type Locator interface {
+	URI() string
+}
+
+// What a Lister knows about an entry: enough to tell whether it has changed.
+type EntryInfo struct {
+	Name         string    `json:"name"`
+	Folder       bool      `json:"folder,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Returns whether this describes the same version of an entry as other.
+func (self EntryInfo) Unchanged(other EntryInfo) bool {
+	return (self.Folder == other.Folder && self.Size == other.Size && self.LastModified.Equal(other.LastModified))
+}
@@ -13,6 +13,22 @@ type Metadata struct {
 	Disc         int                    `json:"disc,omitempty"`
 	Track        int                    `json:"track,omitempty"`
 	Duration     time.Duration          `json:"duration,omitempty"`
+	ReplayGain   *ReplayGain            `json:"replaygain,omitempty"`
 	LastModified time.Time              `json:"last_modified"`
+	Added        time.Time              `json:"added,omitempty"`
 	Extra        map[string]interface{} `json:"extra,omitempty"`
 }
+
+// The ReplayGain values of a song, as read from its tags.  Either of the two may be missing.
+type ReplayGain struct {
+	Track *ReplayGainTuple `json:"track,omitempty"`
+	Album *ReplayGainTuple `json:"album,omitempty"`
+}
+
+type ReplayGainTuple struct {
+	// The adjustment (in dB) needed to bring the song (or album) to the reference loudness.
+	Gain float64 `json:"gain"`
+
+	// The highest absolute sample value, where 1.0 is full scale; zero if unknown.
+	Peak float64 `json:"peak,omitempty"`
+}
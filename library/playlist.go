@@ -0,0 +1,257 @@
+package library
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+)
+
+// The file extensions of the playlist formats that ParsePlaylist understands.
+var PlaylistExtensions = []string{
+	`.m3u`,
+	`.m3u8`,
+	`.pls`,
+	`.xspf`,
+}
+
+// An item in a playlist file.  The location is either a URL or a path, which may be relative to
+// the folder that the playlist is in.
+type PlaylistItem struct {
+	Location string
+	Title    string
+	Duration time.Duration
+}
+
+// Returns whether the location of this item is a URL (rather than a path to a local file).
+func (self *PlaylistItem) IsRemote() bool {
+	return IsRemoteLocation(self.Location)
+}
+
+// Returns whether the given location is a URL for something other than a local file.
+func IsRemoteLocation(location string) bool {
+	if scheme, _ := splitScheme(location); scheme != `` && scheme != `file` {
+		return true
+	}
+
+	return false
+}
+
+// Returns whether the given filename is that of a playlist file.
+func IsPlaylistFile(filename string) bool {
+	ext := strings.ToLower(path.Ext(filename))
+
+	for _, pext := range PlaylistExtensions {
+		if ext == pext {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the items of a playlist, whose format is determined from the given filename.
+func ParsePlaylist(filename string, r io.Reader) ([]*PlaylistItem, error) {
+	var items []*PlaylistItem
+	var err error
+
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case `.m3u`, `.m3u8`:
+		items, err = parseM3U(r)
+	case `.pls`:
+		items, err = parsePLS(r)
+	case `.xspf`:
+		items, err = parseXSPF(r)
+	default:
+		return nil, fmt.Errorf("Unsupported playlist format %q", ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid playlist %v: %v", filename, err)
+	}
+
+	// local files may be given as file:// URLs, and Windows-style paths are common enough
+	for _, item := range items {
+		if scheme, rest := splitScheme(item.Location); scheme == `file` {
+			if unescaped, err := url.PathUnescape(strings.TrimPrefix(rest, `//`)); err == nil {
+				item.Location = unescaped
+			}
+		} else if scheme == `` {
+			item.Location = strings.Replace(item.Location, `\`, `/`, -1)
+		}
+	}
+
+	return items, nil
+}
+
+// M3U playlists have a location on each line, optionally preceded by an "#EXTINF:SECONDS,TITLE"
+// line; all other lines starting with "#" are comments.
+func parseM3U(r io.Reader) ([]*PlaylistItem, error) {
+	items := make([]*PlaylistItem, 0)
+	scanner := bufio.NewScanner(r)
+	next := &PlaylistItem{}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+
+		if strings.HasPrefix(line, `#EXTINF:`) {
+			seconds, title := stringutil.SplitPair(strings.TrimPrefix(line, `#EXTINF:`), `,`)
+
+			// attributes may follow the duration (e.g.: #EXTINF:-1 tvg-id="x",Title)
+			seconds, _ = stringutil.SplitPair(seconds, ` `)
+
+			if v, err := strconv.ParseFloat(seconds, 64); err == nil && v > 0 {
+				next.Duration = time.Duration(v * float64(time.Second))
+			}
+
+			next.Title = strings.TrimSpace(title)
+		} else if line != `` && !strings.HasPrefix(line, `#`) {
+			next.Location = line
+			items = append(items, next)
+			next = &PlaylistItem{}
+		}
+	}
+
+	return items, scanner.Err()
+}
+
+// PLS playlists are INI files with numbered FileN, TitleN and LengthN keys in a [playlist] section.
+func parsePLS(r io.Reader) ([]*PlaylistItem, error) {
+	byNumber := make(map[int]*PlaylistItem)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		key, value := stringutil.SplitPair(line, `=`)
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var field string
+
+		for _, prefix := range []string{`file`, `title`, `length`} {
+			if strings.HasPrefix(key, prefix) {
+				field = prefix
+				break
+			}
+		}
+
+		if field == `` {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+
+		if err != nil {
+			continue
+		}
+
+		item, ok := byNumber[n]
+
+		if !ok {
+			item = &PlaylistItem{}
+			byNumber[n] = item
+		}
+
+		switch field {
+		case `file`:
+			item.Location = value
+		case `title`:
+			item.Title = value
+		case `length`:
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v > 0 {
+				item.Duration = time.Duration(v * float64(time.Second))
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+
+	for n := range byNumber {
+		numbers = append(numbers, n)
+	}
+
+	sort.Ints(numbers)
+	items := make([]*PlaylistItem, 0, len(numbers))
+
+	for _, n := range numbers {
+		if item := byNumber[n]; item.Location != `` {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+type xspfPlaylist struct {
+	Tracks []struct {
+		Location string `xml:"location"`
+		Title    string `xml:"title"`
+		Duration int64  `xml:"duration"`
+	} `xml:"trackList>track"`
+}
+
+// XSPF playlists are XML documents in which each track has a location URI, and optionally a title
+// and a duration in milliseconds.
+func parseXSPF(r io.Reader) ([]*PlaylistItem, error) {
+	var playlist xspfPlaylist
+
+	if err := xml.NewDecoder(r).Decode(&playlist); err != nil {
+		return nil, err
+	}
+
+	items := make([]*PlaylistItem, 0, len(playlist.Tracks))
+
+	for _, track := range playlist.Tracks {
+		location := strings.TrimSpace(track.Location)
+
+		if location == `` {
+			continue
+		}
+
+		// locations are URIs, so relative ones are percent-encoded
+		if scheme, _ := splitScheme(location); scheme == `` {
+			if unescaped, err := url.PathUnescape(location); err == nil {
+				location = unescaped
+			}
+		}
+
+		items = append(items, &PlaylistItem{
+			Location: location,
+			Title:    strings.TrimSpace(track.Title),
+			Duration: time.Duration(track.Duration) * time.Millisecond,
+		})
+	}
+
+	return items, nil
+}
+
+// returns the (lowercased) scheme of a URL and the rest of it (after the colon), or an empty
+// scheme if the location isn't a URL.  Like MPD, only "SCHEME://" counts as a URL, so that
+// filenames like "Live: Part 1.flac" are left alone.
+func splitScheme(location string) (string, string) {
+	if i := strings.Index(location, `:`); i > 1 && strings.HasPrefix(location[i:], `://`) {
+		scheme := location[:i]
+
+		for _, c := range scheme {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.') {
+				return ``, location
+			}
+		}
+
+		return strings.ToLower(scheme), location[i+1:]
+	}
+
+	return ``, location
+}
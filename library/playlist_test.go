@@ -0,0 +1,64 @@
+package library
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsRemoteLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     bool
+	}{
+		{location: `http://radio.example.com/stream`, want: true},
+		{location: `HTTPS://radio.example.com/stream`, want: true},
+		{location: `sftp+x.y://host/file.flac`, want: true},
+		{location: `file:///music/one.flac`, want: false},
+		{location: `music/one.flac`, want: false},
+		{location: `Live: Part 1.flac`, want: false},
+		{location: `music/Live: Part 1.flac`, want: false},
+		{location: `Note:http://example.com`, want: false},
+		{location: `C:\Music\one.flac`, want: false},
+		{location: `mailto:someone@example.com`, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRemoteLocation(tt.location); got != tt.want {
+			t.Errorf("IsRemoteLocation(%q) = %v, want %v", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestParsePlaylistLocations(t *testing.T) {
+	m3u := strings.Join([]string{
+		`#EXTM3U`,
+		`Live: Part 1.flac`,
+		`file:///music/Two%20Words.flac`,
+		`Folder\Three.flac`,
+		`http://radio.example.com/stream`,
+	}, "\n")
+
+	items, err := ParsePlaylist(`test.m3u`, strings.NewReader(m3u))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, len(items))
+
+	for i, item := range items {
+		got[i] = item.Location
+	}
+
+	want := []string{
+		`Live: Part 1.flac`,
+		`/music/Two Words.flac`,
+		`Folder/Three.flac`,
+		`http://radio.example.com/stream`,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePlaylist() locations = %q, want %q", got, want)
+	}
+}
@@ -1,5 +1,10 @@
 package library
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type EntryType int
 
 const (
@@ -29,5 +34,22 @@ func (self EntryType) String() string {
 }
 
 func (self EntryType) MarshalJSON() ([]byte, error) {
-	return []byte(self.String()), nil
+	return json.Marshal(self.String())
+}
+
+func (self *EntryType) UnmarshalJSON(data []byte) error {
+	var name string
+
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	for t := FileEntry; t <= PlaylistEntry; t++ {
+		if t.String() == name {
+			*self = t
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown entry type %q", name)
 }
@@ -3,9 +3,12 @@ package metadata
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/dhowden/tag"
 	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
 )
 
 type AudioLoader struct {
@@ -41,21 +44,46 @@ func (self AudioLoader) LoadMetadata(name string) (map[string]interface{}, error
 
 			self.data = map[string]interface{}{
 				`media`: map[string]interface{}{
-					`artist`:     metadata.Artist(),
-					`album`:      metadata.Album(),
-					`genre`:      metadata.Genre(),
-					`title`:      metadata.Title(),
-					`disc`:       disc,
-					`track`:      track,
-					`year`:       metadata.Year(),
-					`comment`:    metadata.Comment(),
-					`bitrate`:    raw.Int(`bitrate`),
-					`channels`:   raw.Int(`channels`),
-					`samplerate`: raw.Int(`samplerate`),
+					`artist`:      metadata.Artist(),
+					`albumartist`: metadata.AlbumArtist(),
+					`album`:       metadata.Album(),
+					`composer`:    metadata.Composer(),
+					`genre`:       metadata.Genre(),
+					`title`:       metadata.Title(),
+					`disc`:        disc,
+					`track`:       track,
+					`year`:        metadata.Year(),
+					`comment`:     metadata.Comment(),
+					`bitrate`:     raw.Int(`bitrate`),
+					`channels`:    raw.Int(`channels`),
+					`samplerate`:  raw.Int(`samplerate`),
 					// `duration`:   (metadata.Length() / time.Millisecond),
 				},
 			}
 
+			media := self.data[`media`].(map[string]interface{})
+
+			for _, name := range []string{
+				`replaygain_track_gain`,
+				`replaygain_track_peak`,
+				`replaygain_album_gain`,
+				`replaygain_album_peak`,
+			} {
+				if value := rawTag(metadata.Raw(), name); value != `` {
+					if v, err := parseReplayGainValue(value); err == nil {
+						media[name] = v
+					}
+				}
+			}
+
+			if ramp := rawTag(metadata.Raw(), `MIXRAMP_START`); ramp != `` {
+				media[`mixramp_start`] = ramp
+			}
+
+			if ramp := rawTag(metadata.Raw(), `MIXRAMP_END`); ramp != `` {
+				media[`mixramp_end`] = ramp
+			}
+
 			return self.data, nil
 		} else {
 			return nil, fmt.Errorf("parse tags: %v", err)
@@ -64,3 +92,31 @@ func (self AudioLoader) LoadMetadata(name string) (map[string]interface{}, error
 		return nil, fmt.Errorf("read: %v", err)
 	}
 }
+
+// Retrieves a free-form tag (e.g.: MIXRAMP_START) from a file's raw tags.  Depending on the tag
+// format these are stored under their own (variously-cased) name, or as user-defined text frames
+// (ID3v2 TXXX) that carry the name as their description.
+func rawTag(raw map[string]interface{}, name string) string {
+	for key, value := range raw {
+		if comm, ok := value.(*tag.Comm); ok {
+			if strings.EqualFold(comm.Description, name) {
+				return strings.TrimSpace(comm.Text)
+			}
+		} else if strings.EqualFold(key, name) {
+			return strings.TrimSpace(typeutil.String(value))
+		}
+	}
+
+	return ``
+}
+
+// parses ReplayGain tag values, which look like "-6.53 dB" (gains) or "0.988553" (peaks)
+func parseReplayGainValue(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.HasSuffix(strings.ToLower(value), `db`) {
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+
+	return stringutil.ConvertToFloat(value)
+}
@@ -0,0 +1,221 @@
+package metadata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cue sheet timestamps are in minutes, seconds and frames, of which there are 75 per second
+const cueFramesPerSecond = 75
+
+// A CueSheet describes how one or more audio files (typically each holding a whole album) are
+// split up into tracks.
+type CueSheet struct {
+	Title     string
+	Performer string
+	Genre     string
+	Date      string
+	Files     []*CueFile
+}
+
+// An audio file referred to by a cue sheet, and the tracks in it.
+type CueFile struct {
+	Name   string
+	Tracks []*CueTrack
+}
+
+// A track in a cue sheet.  End is the position of the start of the next track in the same file,
+// or zero if this is the last track in its file (and so plays until the end of it).
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration
+	End       time.Duration
+}
+
+// Reads a cue sheet.
+func ParseCueSheet(r io.Reader) (*CueSheet, error) {
+	sheet := &CueSheet{
+		Files: make([]*CueFile, 0),
+	}
+
+	var file *CueFile
+	var track *CueTrack
+
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		command, args := cueFields(line)
+
+		switch strings.ToUpper(command) {
+		case `REM`:
+			if len(args) >= 2 {
+				switch strings.ToUpper(args[0]) {
+				case `GENRE`:
+					sheet.Genre = args[1]
+				case `DATE`:
+					sheet.Date = args[1]
+				}
+			}
+
+		case `TITLE`, `PERFORMER`:
+			if len(args) < 1 {
+				continue
+			}
+
+			if track != nil {
+				if strings.EqualFold(command, `TITLE`) {
+					track.Title = args[0]
+				} else {
+					track.Performer = args[0]
+				}
+			} else if strings.EqualFold(command, `TITLE`) {
+				sheet.Title = args[0]
+			} else {
+				sheet.Performer = args[0]
+			}
+
+		case `FILE`:
+			if len(args) < 1 {
+				return nil, fmt.Errorf("Invalid cue sheet: line %d: missing filename", n)
+			}
+
+			file = &CueFile{
+				Name:   args[0],
+				Tracks: make([]*CueTrack, 0),
+			}
+
+			track = nil
+			sheet.Files = append(sheet.Files, file)
+
+		case `TRACK`:
+			if file == nil {
+				return nil, fmt.Errorf("Invalid cue sheet: line %d: track outside of a file", n)
+			} else if len(args) < 1 {
+				return nil, fmt.Errorf("Invalid cue sheet: line %d: missing track number", n)
+			}
+
+			number, err := strconv.Atoi(args[0])
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cue sheet: line %d: invalid track number %q", n, args[0])
+			}
+
+			track = &CueTrack{
+				Number: number,
+				Start:  -1,
+			}
+
+			// only audio tracks are of interest
+			if len(args) < 2 || strings.EqualFold(args[1], `AUDIO`) {
+				file.Tracks = append(file.Tracks, track)
+			}
+
+		case `INDEX`:
+			// tracks start at index 1; index 0 (if present) marks the start of the gap before it
+			if track != nil && len(args) >= 2 && args[0] != `00` {
+				if start, err := parseCueTime(args[1]); err == nil {
+					if track.Start < 0 {
+						track.Start = start
+					}
+				} else {
+					return nil, fmt.Errorf("Invalid cue sheet: line %d: %v", n, err)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, file := range sheet.Files {
+		tracks := make([]*CueTrack, 0, len(file.Tracks))
+
+		for _, track := range file.Tracks {
+			if track.Start >= 0 {
+				tracks = append(tracks, track)
+			}
+		}
+
+		for i, track := range tracks {
+			if i+1 < len(tracks) {
+				track.End = tracks[i+1].Start
+			}
+		}
+
+		file.Tracks = tracks
+	}
+
+	return sheet, nil
+}
+
+// Returns the performer of the given track, which defaults to that of the whole sheet.
+func (self *CueSheet) TrackPerformer(track *CueTrack) string {
+	if track.Performer != `` {
+		return track.Performer
+	}
+
+	return self.Performer
+}
+
+// splits a line into its command and arguments, which may be double-quoted
+func cueFields(line string) (string, []string) {
+	fields := make([]string, 0)
+
+	for line = strings.TrimSpace(line); line != ``; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			if end := strings.IndexByte(line[1:], '"'); end >= 0 {
+				fields = append(fields, line[1:end+1])
+				line = line[end+2:]
+			} else {
+				fields = append(fields, line[1:])
+				line = ``
+			}
+		} else if end := strings.IndexAny(line, " \t"); end >= 0 {
+			fields = append(fields, line[:end])
+			line = line[end:]
+		} else {
+			fields = append(fields, line)
+			line = ``
+		}
+	}
+
+	if len(fields) == 0 {
+		return ``, nil
+	}
+
+	return fields[0], fields[1:]
+}
+
+// parses a "MM:SS:FF" timestamp
+func parseCueTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, `:`)
+
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	var mm, ss, ff int
+	var err error
+
+	if mm, err = strconv.Atoi(parts[0]); err == nil {
+		if ss, err = strconv.Atoi(parts[1]); err == nil {
+			ff, err = strconv.Atoi(parts[2])
+		}
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	frames := ((mm*60)+ss)*cueFramesPerSecond + ff
+
+	return time.Duration(frames) * time.Second / cueFramesPerSecond, nil
+}
@@ -13,6 +13,7 @@ type LoaderGroup struct {
 	Pass     int
 	Checksum bool
 	Finalize bool
+	Optional bool // optional passes only run when asked for by number
 	Loaders  []Loader
 }
 
@@ -61,6 +62,12 @@ func GetLoaders() LoaderSet {
 			Loaders: []Loader{
 				&VideoLoader{},
 			},
+		}, {
+			Pass:     LoudnessPass,
+			Optional: true,
+			Loaders: []Loader{
+				&LoudnessLoader{},
+			},
 		},
 	}
 }
@@ -79,7 +86,7 @@ func GetLoadersForFile(name string, pass int) []Loader {
 	loaders := make([]Loader, 0)
 
 	for _, group := range GetLoaders() {
-		if pass <= 0 || group.Pass == pass {
+		if (pass <= 0 && !group.Optional) || group.Pass == pass {
 			for _, loader := range group.Loaders {
 				if instance := loader.CanHandle(name); instance != nil {
 					loaders = append(loaders, instance)
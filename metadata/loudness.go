@@ -0,0 +1,423 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/moped/audio"
+)
+
+// The (optional) loader pass that measures the loudness of audio files.
+const LoudnessPass = 3
+
+// The loudness (in LUFS) that ReplayGain values computed from loudness measurements normalize to.
+// This is the ReplayGain 2.0 reference level.
+var LoudnessReference = -18.0
+
+// If set, loudness measurements are saved to (and reused from) this file, since taking them
+// requires decoding every file in its entirety.
+var LoudnessCacheFile string
+
+// Anything quieter than this (in LUFS) is treated as silence, and silent files get no ReplayGain
+// values.  This is the absolute gating threshold used by EBU R128.
+var LoudnessSilence = -70.0
+
+var rxLoudnessIntegrated = regexp.MustCompile(`(?m)^\s*I:\s+(-?[\d\.]+|-inf) LUFS`)
+var rxLoudnessPeak = regexp.MustCompile(`(?m)^\s*Peak:\s+(-?[\d\.]+|-inf) dBFS`)
+var rxLoudnessTime = regexp.MustCompile(`time=(\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+// Returned by LoudnessMetadata when a file hasn't been measured yet.
+var ErrNotMeasured = errors.New(`Loudness has not been measured`)
+
+// The result of measuring a file with ffmpeg's ebur128 filter.  Files that can't be measured are
+// cached too (with the reason why in Error), so that they aren't tried again until they change.
+type Loudness struct {
+	Integrated   float64   `json:"integrated"`
+	TruePeak     float64   `json:"true_peak"`
+	Duration     float64   `json:"duration"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Returns the ReplayGain-style gain (in dB) and peak (where 1.0 is full scale) for this
+// measurement.
+func (self *Loudness) ReplayGain() (float64, float64) {
+	return LoudnessReference - self.Integrated, math.Pow(10, self.TruePeak/20)
+}
+
+// Combines the loudness of several tracks into the loudness of the album they make up.  The
+// integrated loudness is the power average of the tracks' loudness weighted by their duration,
+// and the peak is the highest of the tracks' peaks.
+func AlbumLoudness(tracks []*Loudness) *Loudness {
+	var energy, total float64
+
+	album := &Loudness{
+		TruePeak: silent,
+	}
+
+	for _, track := range tracks {
+		weight := track.Duration
+
+		if weight <= 0 {
+			weight = 1
+		}
+
+		if track.Integrated > LoudnessSilence {
+			energy += weight * math.Pow(10, track.Integrated/10)
+		}
+
+		total += weight
+		album.Duration += track.Duration
+		album.TruePeak = math.Max(album.TruePeak, track.TruePeak)
+	}
+
+	if total > 0 && energy > 0 {
+		album.Integrated = 10 * math.Log10(energy/total)
+	} else {
+		album.Integrated = silent
+	}
+
+	return album
+}
+
+// The LoudnessLoader measures the loudness of audio files and reports it as ReplayGain values, so
+// that files without ReplayGain tags can be normalized too.  Album values are derived from all of
+// the audio files in the same directory.
+type LoudnessLoader struct {
+	Loader
+}
+
+func (self *LoudnessLoader) CanHandle(name string) Loader {
+	if GetGeneralFileType(name) == `audio` {
+		return &LoudnessLoader{}
+	}
+
+	return nil
+}
+
+func (self *LoudnessLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	return LoudnessMetadata(name, true)
+}
+
+// Returns the ReplayGain values derived from the loudness of the given file (and the album it is
+// part of), in the same form as the LoudnessLoader.  Unless measure is set, only measurements that
+// have already been taken are used, and ErrNotMeasured is returned if there aren't any.
+func LoudnessMetadata(name string, measure bool) (map[string]interface{}, error) {
+	track, err := loudness(name, measure)
+
+	if err != nil {
+		return nil, err
+	}
+
+	media := make(map[string]interface{})
+
+	if track.Integrated > LoudnessSilence {
+		gain, peak := track.ReplayGain()
+		media[`replaygain_track_gain`] = gain
+		media[`replaygain_track_peak`] = peak
+	}
+
+	if album, err := measureAlbumLoudness(filepath.Dir(name), measure); err == nil {
+		if album.Integrated > LoudnessSilence {
+			gain, peak := album.ReplayGain()
+			media[`replaygain_album_gain`] = gain
+			media[`replaygain_album_peak`] = peak
+		}
+	} else if measure {
+		log.Warningf("Cannot measure album loudness of %v: %v", filepath.Dir(name), err)
+	}
+
+	return map[string]interface{}{
+		`media`: media,
+	}, nil
+}
+
+// Measures the integrated loudness and true peak of the given file, using a cached measurement if
+// the file hasn't changed since it was last measured.
+func MeasureLoudness(name string) (*Loudness, error) {
+	return loudness(name, true)
+}
+
+// returns the cached measurement of the given file, measuring it if there isn't one (and measure
+// is set)
+func loudness(name string, measure bool) (*Loudness, error) {
+	stat, err := os.Stat(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := loudnessCache.get(name); ok {
+		if cached.Size == stat.Size() && cached.LastModified.Equal(stat.ModTime()) {
+			if cached.Error != `` {
+				return nil, errors.New(cached.Error)
+			}
+
+			return cached, nil
+		}
+	}
+
+	if !measure {
+		return nil, ErrNotMeasured
+	}
+
+	measured, err := runEbur128(name)
+
+	if err != nil {
+		measured = &Loudness{
+			Error: err.Error(),
+		}
+	}
+
+	measured.Size = stat.Size()
+	measured.LastModified = stat.ModTime()
+	loudnessCache.put(name, measured)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return measured, nil
+}
+
+// measures the given file with ffmpeg
+func runEbur128(name string) (*Loudness, error) {
+	var stderr bytes.Buffer
+
+	scan := exec.Command(
+		audio.FFmpegCommandName,
+		`-hide_banner`,
+		`-i`, name,
+		`-vn`,
+		`-filter_complex`, `ebur128=peak=true:framelog=verbose`,
+		`-f`, `null`,
+		`-`,
+	)
+
+	scan.Stderr = &stderr
+	scan.Env = []string{
+		`AV_LOG_FORCE_NOCOLOR=1`,
+	}
+
+	if err := scan.Run(); err != nil {
+		return nil, fmt.Errorf("ebur128: %v", err)
+	}
+
+	return parseLoudness(stderr.String())
+}
+
+// the loudness of the album in a folder, and the files it was derived from
+type albumLoudness struct {
+	files    string
+	loudness *Loudness
+}
+
+// album loudness is kept for each folder, so that it's only worked out once for all of its tracks
+// (until any of them change)
+var albumLoudnessCache = make(map[string]*albumLoudness)
+var albumLoudnessLock sync.Mutex
+
+// Returns the loudness of the album made up of the audio files in the given folder.  Files that
+// can't be measured are left out.
+func measureAlbumLoudness(dir string, measure bool) (*Loudness, error) {
+	infos, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0)
+	var files strings.Builder
+
+	for _, info := range infos {
+		filename := filepath.Join(dir, info.Name())
+
+		if info.IsDir() || GetGeneralFileType(filename) != `audio` {
+			continue
+		}
+
+		filenames = append(filenames, filename)
+		fmt.Fprintf(&files, "%v\x00%d\x00%d\x00", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+
+	albumLoudnessLock.Lock()
+	cached, ok := albumLoudnessCache[dir]
+	albumLoudnessLock.Unlock()
+
+	if ok && cached.files == files.String() {
+		return cached.loudness, nil
+	}
+
+	tracks := make([]*Loudness, 0)
+
+	for _, filename := range filenames {
+		if track, err := loudness(filename, measure); err == nil {
+			tracks = append(tracks, track)
+		} else if err == ErrNotMeasured {
+			return nil, err
+		} else {
+			log.Debugf("Leaving %v out of the album loudness: %v", filename, err)
+		}
+	}
+
+	album := AlbumLoudness(tracks)
+
+	albumLoudnessLock.Lock()
+	albumLoudnessCache[dir] = &albumLoudness{
+		files:    files.String(),
+		loudness: album,
+	}
+	albumLoudnessLock.Unlock()
+
+	return album, nil
+}
+
+// parses the summary that the ebur128 filter prints when it's done (along with the last progress
+// line that ffmpeg prints, which tells us how long the file is)
+func parseLoudness(output string) (*Loudness, error) {
+	loudness := new(Loudness)
+
+	if match := rxLoudnessIntegrated.FindStringSubmatch(output); match != nil {
+		loudness.Integrated = parseDecibels(match[1])
+	} else {
+		return nil, fmt.Errorf("ebur128: no integrated loudness in output")
+	}
+
+	if match := rxLoudnessPeak.FindStringSubmatch(output); match != nil {
+		loudness.TruePeak = parseDecibels(match[1])
+	} else {
+		return nil, fmt.Errorf("ebur128: no true peak in output")
+	}
+
+	if matches := rxLoudnessTime.FindAllStringSubmatch(output, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		hours, _ := stringutil.ConvertToFloat(last[1])
+		minutes, _ := stringutil.ConvertToFloat(last[2])
+		seconds, _ := stringutil.ConvertToFloat(last[3])
+
+		loudness.Duration = (hours * 3600) + (minutes * 60) + seconds
+	}
+
+	return loudness, nil
+}
+
+// what "-inf" is stored as, since infinities can't be represented in JSON
+const silent = -200.0
+
+func parseDecibels(value string) float64 {
+	if value == `-inf` {
+		return silent
+	}
+
+	v, _ := stringutil.ConvertToFloat(value)
+	return v
+}
+
+type loudnessCacheFile struct {
+	filename string
+	entries  map[string]*Loudness
+	loaded   bool
+	pending  *time.Timer
+	lock     sync.Mutex
+}
+
+var loudnessCache = new(loudnessCacheFile)
+
+func (self *loudnessCacheFile) get(name string) (*Loudness, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.load()
+	loudness, ok := self.entries[name]
+
+	return loudness, ok
+}
+
+func (self *loudnessCacheFile) put(name string, loudness *Loudness) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.load()
+	self.entries[name] = loudness
+
+	// measurements tend to come in bunches (a whole album at a time), so rather than rewriting the
+	// cache after every one of them, we wait for things to settle down
+	if self.filename != `` && self.pending == nil {
+		self.pending = time.AfterFunc(5*time.Second, self.save)
+	}
+}
+
+// (re)loads the cache if the cache file has changed; must be called with the lock held
+func (self *loudnessCacheFile) load() {
+	if self.loaded && self.filename == LoudnessCacheFile {
+		return
+	}
+
+	self.filename = LoudnessCacheFile
+	self.entries = make(map[string]*Loudness)
+	self.loaded = true
+
+	if self.filename == `` {
+		return
+	}
+
+	if data, err := ioutil.ReadFile(self.filename); err == nil {
+		if err := json.Unmarshal(data, &self.entries); err != nil {
+			log.Warningf("Ignoring invalid loudness cache %v: %v", self.filename, err)
+			self.entries = make(map[string]*Loudness)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Warningf("Cannot read loudness cache %v: %v", self.filename, err)
+	}
+}
+
+// Writes out any loudness measurements that haven't been saved to the cache file yet.
+func FlushLoudnessCache() {
+	loudnessCache.save()
+}
+
+func (self *loudnessCacheFile) save() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.pending == nil {
+		return
+	}
+
+	self.pending.Stop()
+	self.pending = nil
+
+	if self.filename == `` {
+		return
+	}
+
+	data, err := json.Marshal(self.entries)
+
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(self.filename), 0755); err == nil {
+			tmp := self.filename + `.tmp`
+
+			if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+				err = os.Rename(tmp, self.filename)
+			}
+		}
+	}
+
+	if err != nil {
+		log.Warningf("Cannot write loudness cache %v: %v", self.filename, err)
+	}
+}
@@ -0,0 +1,82 @@
+package moped
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+// The Mixer holds the software volume of a partition, which is applied to the audio sent to each
+// of its outputs (on top of any per-output volume set with the "volume" output attribute).
+type Mixer struct {
+	app    *Partition
+	volume int
+	lock   sync.RWMutex
+}
+
+func NewMixer(app *Partition) *Mixer {
+	return &Mixer{
+		app:    app,
+		volume: 100,
+	}
+}
+
+// Returns the current volume level (0-100).
+func (self *Mixer) Volume() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.volume
+}
+
+// Sets the volume level, which must be between 0 and 100.
+func (self *Mixer) SetVolume(volume int) error {
+	if volume < 0 || volume > 100 {
+		return fmt.Errorf("Invalid volume value")
+	}
+
+	self.update(func(int) int {
+		return volume
+	})
+
+	return nil
+}
+
+// Changes the volume level by the given (possibly negative) amount, clamping the result to the
+// range 0-100.
+func (self *Mixer) AdjustVolume(delta int) error {
+	self.update(func(current int) int {
+		volume := current + delta
+
+		if volume < 0 {
+			volume = 0
+		} else if volume > 100 {
+			volume = 100
+		}
+
+		return volume
+	})
+
+	return nil
+}
+
+// Returns the linear gain factor corresponding to the current volume level.
+func (self *Mixer) Gain() float64 {
+	return audio.VolumeToGain(self.Volume())
+}
+
+// replaces the volume with the value returned by fn (which is given the current volume), all
+// under one lock so that concurrent changes aren't lost
+func (self *Mixer) update(fn func(current int) int) {
+	self.lock.Lock()
+	volume := fn(self.volume)
+	changed := (volume != self.volume)
+	self.volume = volume
+	self.lock.Unlock()
+
+	if changed {
+		self.app.AddChangedSubsystem(`mixer`)
+		self.app.saveStateOrWarn()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"path"
 	"sort"
 	"strings"
 	"sync"
@@ -11,19 +12,36 @@ import (
 
 	"github.com/ghetzel/go-stockutil/log"
 	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/pathutil"
 	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/moped/audio"
 	"github.com/ghetzel/moped/library"
 	"github.com/ghetzel/moped/metadata"
+	"github.com/ghetzel/moped/outputs"
 )
 
 var once sync.Once
 
 type Moped struct {
-	FlattenLibraries bool `json:"flatten_libraries"`
+	FlattenLibraries bool             `json:"flatten_libraries"`
+	AudioFormat      audio.Format     `json:"audio_format"`
+	ReplayGain       audio.ReplayGain `json:"replaygain"`
 	libraries        map[string]library.Library
+	librariesLock    sync.RWMutex
+	mounts           map[string]string
+	failedMounts     map[string]string
 	commands         map[string]cmdHandler
 	clients          sync.Map
 	startedAt        time.Time
+	db               *Database
+	index            *Index
+	updater          *Updater
+	playlists        *PlaylistStore
+	partitions       map[string]*Partition
+	partitionLock    sync.RWMutex
+	outputs          *outputs.Devices
+	stateFile        string
+	stateLock        sync.Mutex
 }
 
 func NewMoped() *Moped {
@@ -32,89 +50,248 @@ func NewMoped() *Moped {
 	})
 
 	moped := &Moped{
-		libraries: make(map[string]library.Library),
+		libraries:    make(map[string]library.Library),
+		mounts:       make(map[string]string),
+		failedMounts: make(map[string]string),
+		outputs:      outputs.NewDevices(),
+		ReplayGain: audio.ReplayGain{
+			Limit: true,
+		},
+	}
+
+	moped.index = NewIndex(moped)
+	moped.updater = NewUpdater(moped)
+	moped.partitions = map[string]*Partition{
+		DefaultPartition: NewPartition(moped, DefaultPartition),
 	}
 
 	moped.commands = map[string]cmdHandler{
-		`close`:            moped.cmdConnection,
-		`commands`:         moped.cmdReflectCommands,
-		`currentsong`:      moped.cmdCurrentSong,
-		`decoders`:         moped.cmdReflectDecoders,
-		`find`:             moped.cmdDbBrowse,
-		`idle`:             moped.cmdIdle,
-		`noidle`:           moped.cmdNoIdle,
-		`kill`:             moped.cmdConnection,
-		`listplaylistinfo`: moped.cmdDbBrowse,
-		`listplaylists`:    moped.cmdPlaylistQueries,
-		`lsinfo`:           moped.cmdDbBrowse,
-		`list`:             moped.cmdDbBrowse,
-		`notcommands`:      moped.cmdReflectNotCommands,
-		`outputs`:          moped.cmdAudio,
-		`password`:         moped.cmdConnection,
-		`ping`:             moped.cmdConnection,
-		`playlist`:         moped.cmdPlaylistQueries,
-		`playlistid`:       moped.cmdPlaylistQueries,
-		`playlistinfo`:     moped.cmdPlaylistQueries,
-		`stats`:            moped.cmdStats,
-		`status`:           moped.cmdStatus,
-		`tagtypes`:         moped.cmdConnection,
-		`urlhandlers`:      moped.cmdReflectUrlHandlers,
+		`add`:                moped.partitioned((*Partition).cmdPlaylistControl),
+		`addid`:              moped.partitioned((*Partition).cmdPlaylistControl),
+		`clear`:              moped.partitioned((*Partition).cmdPlaylistControl),
+		`clearerror`:         moped.partitioned((*Partition).cmdPlayControl),
+		`channels`:           moped.cmdMessaging,
+		`close`:              moped.cmdConnection,
+		`consume`:            moped.partitioned((*Partition).cmdToggles),
+		`crossfade`:          moped.partitioned((*Partition).cmdToggles),
+		`commands`:           moped.cmdReflectCommands,
+		`currentsong`:        moped.partitioned((*Partition).cmdCurrentSong),
+		`decoders`:           moped.cmdReflectDecoders,
+		`delete`:             moped.partitioned((*Partition).cmdPlaylistControl),
+		`delpartition`:       moped.cmdPartitions,
+		`deleteid`:           moped.partitioned((*Partition).cmdPlaylistControl),
+		`disableoutput`:      moped.partitioned((*Partition).cmdAudio),
+		`enableoutput`:       moped.partitioned((*Partition).cmdAudio),
+		`count`:              moped.cmdSearch,
+		`find`:               moped.cmdSearch,
+		`getvol`:             moped.partitioned((*Partition).cmdVolume),
+		`idle`:               moped.cmdIdle,
+		`noidle`:             moped.cmdNoIdle,
+		`kill`:               moped.cmdConnection,
+		`listmounts`:         moped.cmdMounts,
+		`listplaylist`:       moped.cmdStoredPlaylists,
+		`listplaylistinfo`:   moped.cmdStoredPlaylists,
+		`listplaylists`:      moped.cmdStoredPlaylists,
+		`load`:               moped.cmdStoredPlaylists,
+		`listneighbors`:      moped.cmdMounts,
+		`listpartitions`:     moped.cmdPartitions,
+		`lsinfo`:             moped.cmdDbBrowse,
+		`list`:               moped.cmdList,
+		`mixrampdb`:          moped.partitioned((*Partition).cmdToggles),
+		`mixrampdelay`:       moped.partitioned((*Partition).cmdToggles),
+		`mount`:              moped.cmdMounts,
+		`move`:               moped.partitioned((*Partition).cmdPlaylistControl),
+		`moveid`:             moped.partitioned((*Partition).cmdPlaylistControl),
+		`moveoutput`:         moped.cmdPartitions,
+		`newpartition`:       moped.cmdPartitions,
+		`next`:               moped.partitioned((*Partition).cmdPlayControl),
+		`notcommands`:        moped.cmdReflectNotCommands,
+		`outputs`:            moped.partitioned((*Partition).cmdAudio),
+		`outputset`:          moped.partitioned((*Partition).cmdAudio),
+		`partition`:          moped.cmdPartitions,
+		`password`:           moped.cmdConnection,
+		`pause`:              moped.partitioned((*Partition).cmdPlayControl),
+		`ping`:               moped.cmdConnection,
+		`play`:               moped.partitioned((*Partition).cmdPlayControl),
+		`playid`:             moped.partitioned((*Partition).cmdPlayControl),
+		`playlist`:           moped.partitioned((*Partition).cmdPlaylistQueries),
+		`playlistadd`:        moped.cmdStoredPlaylists,
+		`playlistclear`:      moped.cmdStoredPlaylists,
+		`playlistdelete`:     moped.cmdStoredPlaylists,
+		`playlistid`:         moped.partitioned((*Partition).cmdPlaylistQueries),
+		`playlistinfo`:       moped.partitioned((*Partition).cmdPlaylistQueries),
+		`playlistmove`:       moped.cmdStoredPlaylists,
+		`previous`:           moped.partitioned((*Partition).cmdPlayControl),
+		`random`:             moped.partitioned((*Partition).cmdToggles),
+		`rangeid`:            moped.partitioned((*Partition).cmdPlaylistControl),
+		`readmessages`:       moped.cmdMessaging,
+		`replay_gain_mode`:   moped.partitioned((*Partition).cmdReplayGain),
+		`replay_gain_status`: moped.partitioned((*Partition).cmdReplayGain),
+		`rename`:             moped.cmdStoredPlaylists,
+		`repeat`:             moped.partitioned((*Partition).cmdToggles),
+		`rescan`:             moped.cmdUpdate,
+		`rm`:                 moped.cmdStoredPlaylists,
+		`save`:               moped.cmdStoredPlaylists,
+		`search`:             moped.cmdSearch,
+		`seek`:               moped.partitioned((*Partition).cmdPlayControl),
+		`seekcur`:            moped.partitioned((*Partition).cmdPlayControl),
+		`seekid`:             moped.partitioned((*Partition).cmdPlayControl),
+		`sendmessage`:        moped.cmdMessaging,
+		`setvol`:             moped.partitioned((*Partition).cmdVolume),
+		`shuffle`:            moped.partitioned((*Partition).cmdPlaylistControl),
+		`single`:             moped.partitioned((*Partition).cmdToggles),
+		`stats`:              moped.cmdStats,
+		`sticker`:            moped.cmdSticker,
+		`subscribe`:          moped.cmdMessaging,
+		`status`:             moped.partitioned((*Partition).cmdStatus),
+		`stop`:               moped.partitioned((*Partition).cmdPlayControl),
+		`swap`:               moped.partitioned((*Partition).cmdPlaylistControl),
+		`swapid`:             moped.partitioned((*Partition).cmdPlaylistControl),
+		`tagtypes`:           moped.cmdConnection,
+		`toggleoutput`:       moped.partitioned((*Partition).cmdAudio),
+		`unmount`:            moped.cmdMounts,
+		`unsubscribe`:        moped.cmdMessaging,
+		`update`:             moped.cmdUpdate,
+		`urlhandlers`:        moped.cmdReflectUrlHandlers,
+		`volume`:             moped.partitioned((*Partition).cmdVolume),
 		// Not Implemented
-		// NOTSUREIFWANT: https://www.musicpd.org/doc/protocol/mount.html
-		// NOTSUREIFWANT: https://www.musicpd.org/doc/protocol/partition_commands.html
-		// NOTSUREIFWANT: https://www.musicpd.org/doc/protocol/stickers.html
 		// TODO: https://www.musicpd.org/doc/protocol/database.html
-		// TODO: https://www.musicpd.org/doc/protocol/playlist_files.html
-		// `add`:            moped.cmdPlaylistControl,
-		// `addid`:          moped.cmdPlaylistControl,
-		// `addtagid`:       moped.cmdPlaylistControl,
-		// `clear`:          moped.cmdPlaylistControl,
-		// `cleartagid`:     moped.cmdPlaylistControl,
-		// `consume`:        moped.cmdToggles,
-		// `delete`:         moped.cmdPlaylistControl,
-		// `deleteid`:       moped.cmdPlaylistControl,
-		// `disableoutput`:  moped.cmdAudio,
-		// `enableoutput`:   moped.cmdAudio,
-		// `move`:           moped.cmdPlaylistControl,
-		// `moveid`:         moped.cmdPlaylistControl,
-		// `next`:           moped.cmdPlayControl,
-		// `outputset`:      moped.cmdAudio,
-		// `pause`:          moped.cmdPlayControl,
-		// `play`:           moped.cmdPlayControl,
-		// `playid`:         moped.cmdPlayControl,
-		// `playlistfind`:   moped.cmdPlaylistQueries,
-		// `playlistsearch`: moped.cmdPlaylistQueries,
-		// `plchanges`:      moped.cmdPlaylistQueries,
-		// `plchangesposid`: moped.cmdPlaylistQueries,
-		// `previous`:       moped.cmdPlayControl,
-		// `prio`:           moped.cmdPlaylistControl,
-		// `prioid`:         moped.cmdPlaylistControl,
-		// `random`:         moped.cmdToggles,
-		// `rangeid`:        moped.cmdPlaylistControl,
-		// `repeat`:         moped.cmdToggles,
-		// `seek`:           moped.cmdPlayControl,
-		// `seekcur`:        moped.cmdPlayControl,
-		// `seekid`:         moped.cmdPlayControl,
-		// `shuffle`:        moped.cmdPlaylistControl,
-		// `single`:         moped.cmdToggles,
-		// `stop`:           moped.cmdPlayControl,
-		// `swap`:           moped.cmdPlaylistControl,
-		// `swapid`:         moped.cmdPlaylistControl,
-		// `toggleoutput`:   moped.cmdAudio,
+		// `addtagid`:       moped.partitioned((*Partition).cmdPlaylistControl),
+		// `cleartagid`:     moped.partitioned((*Partition).cmdPlaylistControl),
+		// `playlistfind`:   moped.partitioned((*Partition).cmdPlaylistQueries),
+		// `playlistsearch`: moped.partitioned((*Partition).cmdPlaylistQueries),
+		// `plchanges`:      moped.partitioned((*Partition).cmdPlaylistQueries),
+		// `plchangesposid`: moped.partitioned((*Partition).cmdPlaylistQueries),
+		// `prio`:           moped.partitioned((*Partition).cmdPlaylistControl),
+		// `prioid`:         moped.partitioned((*Partition).cmdPlaylistControl),
 	}
 
 	return moped
 }
 
 func (self *Moped) AddLibrary(name string, lib library.Library) error {
+	if lib == nil {
+		return fmt.Errorf("Cannot register nil library")
+	}
+
+	self.librariesLock.Lock()
+
 	if _, ok := self.libraries[name]; ok {
+		self.librariesLock.Unlock()
 		return fmt.Errorf("library '%v' is already registered", name)
-	} else if lib == nil {
-		return fmt.Errorf("Cannot register nil library")
 	}
 
 	self.libraries[name] = lib
+	self.librariesLock.Unlock()
+
+	self.index.Invalidate()
 	log.Debugf("Registered %T library: %v", lib, name)
+
+	// libraries that can tell us when they change are kept up to date as they do
+	if watcher, ok := lib.(library.Watcher); ok {
+		err := watcher.Watch(func(folders []string) {
+			paths := make([]string, len(folders))
+
+			for i, folder := range folders {
+				paths[i] = path.Join(name, folder)
+			}
+
+			if _, err := self.updater.Start(false, paths...); err != nil {
+				log.Warningf("Cannot update library %v: %v", name, err)
+			}
+		})
+
+		if err != nil {
+			log.Warningf("Cannot watch library %v for changes: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the names of all registered libraries, in sorted order.
+func (self *Moped) LibraryNames() []string {
+	self.librariesLock.RLock()
+	defer self.librariesLock.RUnlock()
+
+	names := maputil.StringKeys(self.libraries)
+	sort.Strings(names)
+
+	return names
+}
+
+// Returns the library with the given name.
+func (self *Moped) Library(name string) (library.Library, bool) {
+	self.librariesLock.RLock()
+	defer self.librariesLock.RUnlock()
+
+	lib, ok := self.libraries[name]
+	return lib, ok
+}
+
+// Opens (or creates) the database that library entries and their metadata are kept in, so that
+// browsing and searching don't have to go to the libraries themselves.  Libraries that aren't in
+// the database yet are scanned in the background.  This should be called after all libraries have
+// been added.
+func (self *Moped) SetDatabase(filename string) error {
+	if filename == `` {
+		return nil
+	}
+
+	if expanded, err := pathutil.ExpandUser(filename); err == nil {
+		filename = expanded
+	} else {
+		return err
+	}
+
+	if db, err := OpenDatabase(filename); err == nil {
+		self.db = db
+		self.index.Invalidate()
+
+		go func() {
+			if _, err := self.index.Entries(); err != nil {
+				log.Errorf("Failed to index libraries: %v", err)
+			}
+		}()
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns when the song database was last updated.
+func (self *Moped) LastUpdated() time.Time {
+	if self.db == nil {
+		return self.index.Updated()
+	}
+
+	var updated time.Time
+
+	for _, name := range self.LibraryNames() {
+		if scanned := self.db.LastScanned(name); scanned.After(updated) {
+			updated = scanned
+		}
+	}
+
+	return updated
+}
+
+func (self *Moped) AddOutput(device *outputs.Device) error {
+	if device == nil {
+		return fmt.Errorf("Cannot register nil output")
+	}
+
+	if err := self.outputs.Add(device); err != nil {
+		return err
+	}
+
+	// outputs start out in the default partition
+	if err := self.DefaultPartition().outputs.Attach(device); err != nil {
+		return err
+	}
+
+	log.Debugf("Registered %v output %d: %v", device.Type, device.ID, device.Name)
 	return nil
 }
 
@@ -137,8 +314,10 @@ func (self *Moped) Listen(network string, address string) error {
 }
 
 func (self *Moped) Ping() error {
-	for name, lib := range self.libraries {
-		if err := lib.Ping(); err != nil {
+	for _, name := range self.LibraryNames() {
+		if lib, ok := self.Library(name); !ok {
+			continue
+		} else if err := lib.Ping(); err != nil {
 			return fmt.Errorf("library %v: %v", name, err)
 		}
 	}
@@ -150,7 +329,7 @@ func (self *Moped) GetLibraryForPath(entryPath string) (string, string, library.
 	entryPath = strings.TrimPrefix(entryPath, `/`)
 
 	if name, rest := stringutil.SplitPair(entryPath, `/`); name != `` {
-		if lib, ok := self.libraries[name]; ok {
+		if lib, ok := self.Library(name); ok {
 			return name, rest, lib, true
 		} else {
 			return name, rest, nil, false
@@ -162,7 +341,24 @@ func (self *Moped) GetLibraryForPath(entryPath string) (string, string, library.
 
 func (self *Moped) Browse(entryPath string) (library.EntryList, error) {
 	if name, rest, lib, ok := self.GetLibraryForPath(entryPath); ok {
-		if entries, err := lib.Browse(rest); err == nil {
+		var entries library.EntryList
+		var err error
+
+		// libraries are browsed from the database once they've been scanned into it
+		if self.db != nil && self.db.HasLibrary(name) {
+			entries, err = self.db.Browse(name, rest)
+
+			// the database only has the playlist itself; what's in it comes from the library
+			if err == nil && len(entries) == 1 && entries[0].Type == library.PlaylistEntry {
+				if strings.Trim(entries[0].Path, `/`) == strings.Trim(rest, `/`) {
+					entries, err = lib.Browse(rest)
+				}
+			}
+		} else {
+			entries, err = lib.Browse(rest)
+		}
+
+		if err == nil {
 			for _, entry := range entries {
 				entry.SetParentPath(name)
 			}
@@ -174,17 +370,14 @@ func (self *Moped) Browse(entryPath string) (library.EntryList, error) {
 	} else if name == `` {
 		libraries := make(library.EntryList, 0)
 
-		keys := maputil.StringKeys(self.libraries)
-		sort.Strings(keys)
-
-		for _, name := range keys {
+		for _, name := range self.LibraryNames() {
 			if self.FlattenLibraries {
 				if topLevelEntries, err := self.Browse(name); err == nil {
 					libraries = append(libraries, topLevelEntries...)
 				} else {
 					return nil, err
 				}
-			} else if _, ok := self.libraries[name]; ok {
+			} else if _, ok := self.Library(name); ok {
 				libraries = append(libraries, &library.Entry{
 					Path: `/` + name,
 					Type: library.FolderEntry,
@@ -214,30 +407,139 @@ func (self *Moped) Get(entryPath string) (*library.Entry, error) {
 	}
 }
 
+// Recursively visits every entry at or beneath the given path, calling fn for each non-folder
+// entry in sorted order.  If the path is that of a playlist, fn is called for each of its songs.
+func (self *Moped) Walk(entryPath string, fn func(entry *library.Entry) error) error {
+	if entries, err := self.Browse(entryPath); err == nil {
+		// the songs in a playlist are visited in the order it lists them
+		if !library.IsPlaylistFile(entryPath) {
+			sort.Sort(entries)
+		}
+
+		for _, entry := range entries {
+			if entry.IsHidden() {
+				continue
+			}
+
+			if entry.Type == library.FolderEntry {
+				if err := self.Walk(entry.FullPath(), fn); err != nil {
+					return err
+				}
+			} else if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns all of the songs in every library that match the given filter.
+func (self *Moped) Search(filter library.Filter) (library.EntryList, error) {
+	return self.index.Search(filter)
+}
+
 func (self *Moped) DropClient(id string) error {
 	if clientI, ok := self.clients.Load(id); ok {
+		client := clientI.(*Client)
+
 		defer func(cid string) {
 			self.clients.Delete(cid)
 			log.Debugf("Client %v removed", cid)
+
+			// the client's subscriptions go away with it
+			if len(client.Channels()) > 0 {
+				self.AddChangedSubsystem(`subscription`)
+			}
 		}(id)
 
-		return clientI.(*Client).Close()
+		return client.Close()
 	} else {
 		return nil
 	}
 }
 
-func (self *Moped) AddChangedSubsystem(subsystem string) {
+// Notifies clients that the given subsystem has changed.  If any channels are given, only the
+// clients that are subscribed to at least one of them are notified.
+func (self *Moped) AddChangedSubsystem(subsystem string, channels ...string) {
 	self.clients.Range(func(id interface{}, clientI interface{}) bool {
-		clientI.(*Client).AddChangedSubsystem(subsystem)
+		client := clientI.(*Client)
+
+		if len(channels) == 0 || client.IsSubscribed(channels...) {
+			client.AddChangedSubsystem(subsystem)
+		}
+
 		return true
 	})
 }
 
-func (self *Moped) Stop() error {
+// Returns the channels that at least one client is subscribed to, in sorted order.
+func (self *Moped) Channels() []string {
+	channels := make(map[string]bool)
+
+	self.clients.Range(func(id interface{}, clientI interface{}) bool {
+		for _, channel := range clientI.(*Client).Channels() {
+			channels[channel] = true
+		}
+
+		return true
+	})
+
+	names := maputil.StringKeys(channels)
+	sort.Strings(names)
+
+	return names
+}
+
+// Sends a message to every client subscribed to the given channel, and wakes them up.  It is an
+// error for nobody to be subscribed, or for every subscriber's queue of unread messages to be full.
+func (self *Moped) SendMessage(channel string, text string) error {
+	var subscribed, sent bool
+
+	message := &ClientMessage{
+		Channel: channel,
+		Message: text,
+	}
+
+	self.clients.Range(func(id interface{}, clientI interface{}) bool {
+		s, q := clientI.(*Client).PushMessage(message)
+		subscribed = subscribed || s
+		sent = sent || q
+
+		return true
+	})
+
+	if !subscribed {
+		return fmt.Errorf("Nobody is subscribed to this channel")
+	} else if !sent {
+		return fmt.Errorf("The message queues of all subscribers are full")
+	}
+
+	self.AddChangedSubsystem(`message`, channel)
 	return nil
 }
 
+func (self *Moped) Stop() error {
+	metadata.FlushLoudnessCache()
+	var err error
+
+	for _, partition := range self.Partitions() {
+		if perr := partition.player.Stop(); perr != nil && err == nil {
+			err = perr
+		}
+	}
+
+	if self.db != nil {
+		if cerr := self.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
 func (self *Moped) handleClient(conn net.Conn) {
 	client := NewClient(self, conn)
 	self.clients.Store(client.ID(), client)
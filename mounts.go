@@ -0,0 +1,112 @@
+package moped
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/backends"
+	"github.com/ghetzel/moped/library"
+)
+
+// Attaches the storage at the given URI (e.g.: "file:///mnt/usb") as a new library with the given
+// name, and starts scanning it.  Mounted libraries are remembered across restarts.
+func (self *Moped) Mount(name string, uri string) error {
+	if name == `` || strings.Contains(name, `/`) || strings.HasPrefix(name, `.`) {
+		return fmt.Errorf("Invalid mount point %q", name)
+	}
+
+	if _, ok := self.Library(name); ok {
+		return fmt.Errorf("Mount point is already in use")
+	}
+
+	lib, err := backends.Mount(uri)
+
+	if err != nil {
+		return err
+	}
+
+	if err := self.AddLibrary(name, lib); err != nil {
+		return err
+	}
+
+	self.librariesLock.Lock()
+	self.mounts[name] = uri
+	delete(self.failedMounts, name)
+	self.librariesLock.Unlock()
+
+	log.Infof("Mounted %v on %v", uri, name)
+	self.AddChangedSubsystem(`mount`)
+	self.saveStateOrWarn()
+
+	if _, err := self.updater.Start(false, name); err != nil {
+		log.Warningf("Cannot update library %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// Detaches a library that was attached with Mount, and removes it from the database.  Libraries
+// from the configuration can't be unmounted.  Unmounting a mount that couldn't be restored at
+// startup just forgets about it.
+func (self *Moped) Unmount(name string) error {
+	self.librariesLock.Lock()
+
+	if _, ok := self.failedMounts[name]; ok {
+		delete(self.failedMounts, name)
+		self.librariesLock.Unlock()
+
+		log.Infof("Forgot mount %v", name)
+		self.saveStateOrWarn()
+
+		return nil
+	} else if _, ok := self.mounts[name]; !ok {
+		self.librariesLock.Unlock()
+
+		if _, ok := self.libraries[name]; ok {
+			return fmt.Errorf("Cannot unmount a configured library")
+		} else {
+			return fmt.Errorf("Not a mount point")
+		}
+	}
+
+	lib := self.libraries[name]
+	delete(self.mounts, name)
+	delete(self.libraries, name)
+	self.librariesLock.Unlock()
+
+	if closer, ok := lib.(library.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Warningf("Failed to close library %v: %v", name, err)
+		}
+	}
+
+	if self.db != nil {
+		if err := self.db.Forget(name); err != nil {
+			log.Warningf("Failed to remove library %v from the database: %v", name, err)
+		}
+	}
+
+	self.index.Invalidate()
+
+	log.Infof("Unmounted %v", name)
+	self.AddChangedSubsystem(`mount`)
+	self.AddChangedSubsystem(`database`)
+	self.saveStateOrWarn()
+
+	return nil
+}
+
+// Returns the storage URIs of the libraries that were attached with Mount, keyed by name.
+func (self *Moped) Mounts() map[string]string {
+	self.librariesLock.RLock()
+	defer self.librariesLock.RUnlock()
+
+	mounts := make(map[string]string)
+
+	for name, uri := range self.mounts {
+		mounts[name] = uri
+	}
+
+	return mounts
+}
@@ -0,0 +1,305 @@
+package outputs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+)
+
+// Outputs that support runtime attributes (as set by the "outputset" command) implement this
+// interface.  Outputs that don't are still able to have attributes set on them, but they are
+// only stored, not acted upon.
+type Configurable interface {
+	SetAttribute(name string, value string) error
+}
+
+// The attribute that sets a device's own software volume (0-100), which is applied on top of the
+// global volume.
+const VolumeAttribute = `volume`
+
+// A Device is a configured, named instance of an Output, as exposed to clients.
+type Device struct {
+	ID         int
+	Name       string
+	Type       string
+	enabled    bool
+	attributes map[string]string
+	volume     int
+	output     Output
+	format     audio.Format
+	open       bool
+	lock       sync.Mutex
+}
+
+func NewDevice(name string, outputType string, output Output) *Device {
+	return &Device{
+		Name:       name,
+		Type:       outputType,
+		enabled:    true,
+		attributes: make(map[string]string),
+		volume:     100,
+		output:     output,
+	}
+}
+
+func (self *Device) IsEnabled() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.enabled
+}
+
+// Enables or disables the device.  Disabled devices are closed, and receive no audio until they
+// are enabled again.
+func (self *Device) SetEnabled(enabled bool) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.enabled = enabled
+
+	if !enabled && self.open {
+		self.open = false
+		return self.output.Close()
+	}
+
+	return nil
+}
+
+// Returns a copy of the device's runtime attributes.
+func (self *Device) Attributes() map[string]string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	attributes := make(map[string]string)
+
+	for k, v := range self.attributes {
+		attributes[k] = v
+	}
+
+	return attributes
+}
+
+// Sets a runtime attribute on the device, passing it along to the output if it supports it.
+func (self *Device) SetAttribute(name string, value string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if name == VolumeAttribute {
+		if v, err := stringutil.ConvertToInteger(value); err == nil && v >= 0 && v <= 100 {
+			self.volume = int(v)
+			self.attributes[name] = value
+			return nil
+		} else {
+			return fmt.Errorf("Invalid volume value %q", value)
+		}
+	}
+
+	if configurable, ok := self.output.(Configurable); ok {
+		if err := configurable.SetAttribute(name, value); err != nil {
+			return err
+		}
+	}
+
+	self.attributes[name] = value
+	return nil
+}
+
+// Returns the device's own volume level (0-100).
+func (self *Device) Volume() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.volume
+}
+
+// Returns the underlying output.
+func (self *Device) Output() Output {
+	return self.output
+}
+
+// Returns whether the device is currently open, and in what format.
+func (self *Device) IsOpen() (audio.Format, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.format, self.open
+}
+
+// Opens the device in the given format, reopening it if it is already open in a different one.
+func (self *Device) Open(format audio.Format) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.open {
+		if self.format == format {
+			return nil
+		}
+
+		self.output.Close()
+		self.open = false
+	}
+
+	if err := self.output.Open(format); err != nil {
+		return fmt.Errorf("output %q: %v", self.Name, err)
+	}
+
+	self.format = format
+	self.open = true
+
+	return nil
+}
+
+func (self *Device) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.open {
+		return 0, fmt.Errorf("output %q is not open", self.Name)
+	}
+
+	return self.output.Write(data)
+}
+
+// Informs the output (if it cares) that a new song is starting.
+func (self *Device) SongChanged(entry *library.Entry) {
+	if aware, ok := self.output.(SongAware); ok {
+		aware.SongChanged(entry)
+	}
+}
+
+func (self *Device) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.open {
+		self.open = false
+		return self.output.Close()
+	}
+
+	return nil
+}
+
+// The set of all output devices, in the order they were added.
+type Devices struct {
+	devices []*Device
+	lock    sync.RWMutex
+}
+
+func NewDevices() *Devices {
+	return &Devices{
+		devices: make([]*Device, 0),
+	}
+}
+
+// Adds a device, assigning it the next available ID.
+func (self *Devices) Add(device *Device) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, existing := range self.devices {
+		if existing.Name == device.Name {
+			return fmt.Errorf("output %q is already registered", device.Name)
+		}
+	}
+
+	device.ID = len(self.devices)
+	self.devices = append(self.devices, device)
+
+	return nil
+}
+
+// Adds a device that has already been assigned an ID by another set (such as when a device is
+// moved from one partition to another).  Devices are kept in order of their IDs.
+func (self *Devices) Attach(device *Device) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	i := 0
+
+	for ; i < len(self.devices); i++ {
+		if existing := self.devices[i]; existing.Name == device.Name {
+			return fmt.Errorf("output %q is already registered", device.Name)
+		} else if existing.ID > device.ID {
+			break
+		}
+	}
+
+	self.devices = append(self.devices, nil)
+	copy(self.devices[i+1:], self.devices[i:])
+	self.devices[i] = device
+
+	return nil
+}
+
+// Removes the device with the given name from the set, returning it.
+func (self *Devices) Remove(name string) (*Device, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for i, device := range self.devices {
+		if device.Name == name {
+			self.devices = append(self.devices[:i], self.devices[i+1:]...)
+			return device, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns all devices.
+func (self *Devices) List() []*Device {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	devices := make([]*Device, len(self.devices))
+	copy(devices, self.devices)
+
+	return devices
+}
+
+// Returns the device with the given ID.
+func (self *Devices) Get(id int) (*Device, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for _, device := range self.devices {
+		if device.ID == id {
+			return device, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns the device with the given name.
+func (self *Devices) GetByName(name string) (*Device, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for _, device := range self.devices {
+		if device.Name == name {
+			return device, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns all devices that are currently enabled.
+func (self *Devices) Enabled() []*Device {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	devices := make([]*Device, 0)
+
+	for _, device := range self.devices {
+		if device.IsEnabled() {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
@@ -0,0 +1,162 @@
+package outputs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghetzel/go-stockutil/pathutil"
+	"github.com/ghetzel/moped/audio"
+	"github.com/mcuadros/go-defaults"
+)
+
+const wavHeaderSize = 44
+
+func init() {
+	Register(`file`, func(config map[string]interface{}) (Output, error) {
+		var cfg FileConfig
+
+		if err := configure(config, &cfg); err != nil {
+			return nil, err
+		}
+
+		return NewFileOutput(&cfg)
+	})
+}
+
+type FileConfig struct {
+	Path   string `json:"path"`
+	Format string `json:"format" default:"wav"`
+}
+
+// The FileOutput writes audio to a file, either as a WAV file or as raw PCM.  The file is only
+// truncated the first time it is opened; after that (e.g.: when the output is reopened because the
+// audio format changed), audio is added to the end of it.  Since a WAV file can only hold audio in
+// one format, it is started over if the format changes.
+type FileOutput struct {
+	config  *FileConfig
+	file    *os.File
+	format  audio.Format
+	written int64
+	opened  bool
+}
+
+func NewFileOutput(config *FileConfig) (*FileOutput, error) {
+	if config == nil {
+		config = &FileConfig{}
+	}
+
+	defaults.SetDefaults(config)
+
+	if config.Path == `` {
+		return nil, fmt.Errorf("Must specify a path for a file output")
+	}
+
+	switch config.Format {
+	case `wav`, `raw`:
+		break
+	default:
+		return nil, fmt.Errorf("Unsupported file output format %q (must be 'wav' or 'raw')", config.Format)
+	}
+
+	if path, err := pathutil.ExpandUser(config.Path); err == nil {
+		config.Path = path
+	} else {
+		return nil, err
+	}
+
+	return &FileOutput{
+		config: config,
+	}, nil
+}
+
+func (self *FileOutput) Open(format audio.Format) error {
+	if self.config.Format == `wav` && format.Bits == 8 {
+		return fmt.Errorf("WAV files cannot hold signed 8-bit audio")
+	}
+
+	appending := self.opened && (self.config.Format == `raw` || format == self.format)
+	flags := os.O_WRONLY | os.O_CREATE
+
+	if !appending {
+		flags |= os.O_TRUNC
+	}
+
+	if file, err := os.OpenFile(self.config.Path, flags, 0644); err == nil {
+		self.file = file
+		self.format = format
+		self.opened = true
+	} else {
+		return err
+	}
+
+	if appending {
+		// not possible (nor necessary) for FIFOs and the like, which are always written at the end
+		self.file.Seek(0, io.SeekEnd)
+		return nil
+	}
+
+	self.written = 0
+
+	if self.config.Format == `wav` {
+		// the sizes are filled in on close (where possible); until then, use the largest allowed
+		// values so that readers of a non-seekable file (e.g. a FIFO) play everything they get
+		return self.writeWavHeader(0xFFFFFFFF - wavHeaderSize)
+	}
+
+	return nil
+}
+
+func (self *FileOutput) Write(data []byte) (int, error) {
+	if self.file == nil {
+		return 0, fmt.Errorf("file output is not open")
+	}
+
+	n, err := self.file.Write(data)
+	self.written += int64(n)
+
+	return n, err
+}
+
+func (self *FileOutput) Close() error {
+	if self.file == nil {
+		return nil
+	}
+
+	defer func() {
+		self.file = nil
+	}()
+
+	if self.config.Format == `wav` {
+		if _, err := self.file.Seek(0, io.SeekStart); err == nil {
+			if err := self.writeWavHeader(uint32(self.written)); err != nil {
+				self.file.Close()
+				return err
+			}
+		}
+	}
+
+	return self.file.Close()
+}
+
+func (self *FileOutput) writeWavHeader(dataSize uint32) error {
+	header := make([]byte, wavHeaderSize)
+
+	copy(header[0:4], `RIFF`)
+	binary.LittleEndian.PutUint32(header[4:8], dataSize+wavHeaderSize-8)
+	copy(header[8:12], `WAVE`)
+	copy(header[12:16], `fmt `)
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(self.format.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(self.format.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(self.format.BytesPerSecond()))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(self.format.FrameSize()))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(self.format.Bits))
+	copy(header[36:40], `data`)
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+
+	_, err := self.file.Write(header)
+	return err
+}
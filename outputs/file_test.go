@@ -0,0 +1,136 @@
+package outputs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+var cdFormat = audio.Format{SampleRate: 44100, Bits: 16, Channels: 2}
+var dvdFormat = audio.Format{SampleRate: 48000, Bits: 24, Channels: 2}
+
+// a chunk of PCM written to an output after opening it in the given format
+type fileWrite struct {
+	format audio.Format
+	data   []byte
+}
+
+func TestFileOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		writes []fileWrite
+		data   []byte
+		header *audio.Format
+	}{
+		{
+			name:   `raw`,
+			format: `raw`,
+			writes: []fileWrite{{cdFormat, []byte{1, 2, 3, 4}}},
+			data:   []byte{1, 2, 3, 4},
+		}, {
+			name:   `raw reopened in another format keeps earlier audio`,
+			format: `raw`,
+			writes: []fileWrite{{cdFormat, []byte{1, 2, 3, 4}}, {dvdFormat, []byte{5, 6, 7, 8, 9, 10}}},
+			data:   []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		}, {
+			name:   `wav`,
+			format: `wav`,
+			writes: []fileWrite{{cdFormat, []byte{1, 2, 3, 4}}},
+			data:   []byte{1, 2, 3, 4},
+			header: &cdFormat,
+		}, {
+			name:   `wav reopened in the same format keeps earlier audio`,
+			format: `wav`,
+			writes: []fileWrite{{cdFormat, []byte{1, 2, 3, 4}}, {cdFormat, []byte{5, 6, 7, 8}}},
+			data:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			header: &cdFormat,
+		}, {
+			name:   `wav reopened in another format starts over`,
+			format: `wav`,
+			writes: []fileWrite{{cdFormat, []byte{1, 2, 3, 4}}, {dvdFormat, []byte{5, 6, 7, 8, 9, 10}}},
+			data:   []byte{5, 6, 7, 8, 9, 10},
+			header: &dvdFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(``, `moped-file-output`)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, `out`)
+			output, err := NewFileOutput(&FileConfig{
+				Path:   filename,
+				Format: tt.format,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tt.writes {
+				if err := output.Open(w.format); err != nil {
+					t.Fatal(err)
+				}
+
+				if n, err := output.Write(w.data); err != nil || n != len(w.data) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+
+				if err := output.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			contents, err := ioutil.ReadFile(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.header != nil {
+				if len(contents) < wavHeaderSize {
+					t.Fatalf("file is too short to have a WAV header (%d bytes)", len(contents))
+				}
+
+				header := contents[:wavHeaderSize]
+				contents = contents[wavHeaderSize:]
+
+				if string(header[0:4]) != `RIFF` || string(header[8:12]) != `WAVE` {
+					t.Errorf("bad WAV header %q", header[:12])
+				}
+
+				if v := binary.LittleEndian.Uint16(header[22:24]); int(v) != tt.header.Channels {
+					t.Errorf("header has %d channels, want %d", v, tt.header.Channels)
+				}
+
+				if v := binary.LittleEndian.Uint32(header[24:28]); int(v) != tt.header.SampleRate {
+					t.Errorf("header has sample rate %d, want %d", v, tt.header.SampleRate)
+				}
+
+				if v := binary.LittleEndian.Uint16(header[34:36]); int(v) != tt.header.Bits {
+					t.Errorf("header has %d bits, want %d", v, tt.header.Bits)
+				}
+
+				if v := binary.LittleEndian.Uint32(header[40:44]); int(v) != len(tt.data) {
+					t.Errorf("header has data size %d, want %d", v, len(tt.data))
+				}
+			}
+
+			if !bytes.Equal(contents, tt.data) {
+				t.Errorf("file contains %v, want %v", contents, tt.data)
+			}
+		})
+	}
+}
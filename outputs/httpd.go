@@ -0,0 +1,597 @@
+package outputs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+	"github.com/mcuadros/go-defaults"
+)
+
+// how many bytes of audio are sent to ICY-aware listeners between metadata blocks
+var HttpdMetadataInterval = 8192
+
+// how many chunks of audio may be queued up for a listener before it is considered too slow
+// and disconnected
+var HttpdListenerBacklog = 256
+
+type httpdEncoder struct {
+	ContentType string
+	Arguments   []string
+}
+
+var httpdEncoders = map[string]httpdEncoder{
+	`mp3`: {
+		ContentType: `audio/mpeg`,
+		Arguments:   []string{`-f`, `mp3`, `-c:a`, `libmp3lame`, `-write_xing`, `0`},
+	},
+	`opus`: {
+		ContentType: `audio/ogg`,
+		Arguments:   []string{`-f`, `ogg`, `-c:a`, `libopus`},
+	},
+	`flac`: {
+		ContentType: `audio/flac`,
+		Arguments:   []string{`-f`, `flac`, `-c:a`, `flac`},
+	},
+}
+
+func init() {
+	Register(`httpd`, func(config map[string]interface{}) (Output, error) {
+		var cfg HttpdConfig
+
+		if err := configure(config, &cfg); err != nil {
+			return nil, err
+		}
+
+		return NewHttpdOutput(&cfg)
+	})
+}
+
+type HttpdConfig struct {
+	Address    string `json:"address"     default:":8000"`
+	Encoder    string `json:"encoder"     default:"mp3"`
+	Bitrate    int    `json:"bitrate"     default:"192"`
+	Name       string `json:"name"        default:"moped"`
+	MaxClients int    `json:"max_clients"`
+
+	// The format ("samplerate:bits:channels") that the stream is encoded in, whatever the format of
+	// the songs being played.  Defaults to 48000:16:2 for Opus (which doesn't support 44.1kHz), and
+	// 44100:16:2 otherwise.
+	Format string `json:"format"`
+}
+
+type httpdListener struct {
+	chunks chan []byte
+	closed bool
+}
+
+// The HttpdOutput encodes audio and streams it over HTTP to any number of listeners, in the
+// style of Shoutcast/Icecast (including support for ICY metadata).
+//
+// The stream is always encoded in the same format, so that it can carry on across songs in
+// different formats: when the output is reopened in a new format, only the encoder is restarted,
+// and listeners stay connected.  While the output is closed (e.g.: playback is stopped), listeners
+// stay connected but receive nothing.
+type HttpdOutput struct {
+	config    *HttpdConfig
+	encoder   httpdEncoder
+	format    audio.Format
+	listener  net.Listener
+	proc      *exec.Cmd
+	stdin     io.WriteCloser
+	done      chan struct{}
+	header    []byte
+	hasHeader bool
+	title     string
+	listeners map[*httpdListener]bool
+	lock      sync.Mutex
+}
+
+func NewHttpdOutput(config *HttpdConfig) (*HttpdOutput, error) {
+	if config == nil {
+		config = &HttpdConfig{}
+	}
+
+	defaults.SetDefaults(config)
+
+	encoder, ok := httpdEncoders[config.Encoder]
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported encoder %q (must be one of: mp3, opus, flac)", config.Encoder)
+	}
+
+	if config.Format == `` {
+		if config.Encoder == `opus` {
+			config.Format = `48000:16:2`
+		} else {
+			config.Format = `44100:16:2`
+		}
+	}
+
+	format, err := audio.ParseFormat(config.Format)
+
+	if err != nil {
+		return nil, err
+	} else if format.SampleRate <= 0 || format.Channels <= 0 {
+		return nil, fmt.Errorf("The httpd output format must specify a sample rate and channels")
+	}
+
+	return &HttpdOutput{
+		config:    config,
+		encoder:   encoder,
+		format:    format,
+		listeners: make(map[*httpdListener]bool),
+	}, nil
+}
+
+// Returns the address the output is listening on (once it has been opened for the first time).
+func (self *HttpdOutput) Addr() net.Addr {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.listener != nil {
+		return self.listener.Addr()
+	}
+
+	return nil
+}
+
+func (self *HttpdOutput) Open(format audio.Format) error {
+	if err := self.listen(); err != nil {
+		return err
+	}
+
+	args := []string{
+		`-hide_banner`,
+		`-v`, `error`,
+		`-f`, format.SampleFormat(),
+		`-ar`, fmt.Sprintf("%d", format.SampleRate),
+		`-ac`, fmt.Sprintf("%d", format.Channels),
+		`-i`, `pipe:0`,
+		`-ar`, fmt.Sprintf("%d", self.format.SampleRate),
+		`-ac`, fmt.Sprintf("%d", self.format.Channels),
+	}
+
+	if self.format.Bits > 0 && self.config.Encoder == `flac` {
+		args = append(args, `-sample_fmt`, flacSampleFormat(self.format.Bits))
+	}
+
+	args = append(args, self.encoder.Arguments...)
+
+	if self.config.Encoder != `flac` && self.config.Bitrate > 0 {
+		args = append(args, `-b:a`, fmt.Sprintf("%dk", self.config.Bitrate))
+	}
+
+	args = append(args, `pipe:1`)
+
+	proc := exec.Command(audio.FFmpegCommandName, args...)
+	proc.Env = []string{
+		`AV_LOG_FORCE_NOCOLOR=1`,
+	}
+
+	stdin, err := proc.StdinPipe()
+
+	if err != nil {
+		return err
+	}
+
+	stdout, err := proc.StdoutPipe()
+
+	if err != nil {
+		return err
+	}
+
+	if err := proc.Start(); err != nil {
+		return fmt.Errorf("encoder: %v", err)
+	}
+
+	self.lock.Lock()
+	self.proc = proc
+	self.stdin = stdin
+	self.done = make(chan struct{})
+	self.lock.Unlock()
+
+	go self.broadcast(bufio.NewReader(stdout), self.done)
+
+	return nil
+}
+
+func (self *HttpdOutput) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	stdin := self.stdin
+	self.lock.Unlock()
+
+	if stdin == nil {
+		return 0, fmt.Errorf("httpd output is not open")
+	}
+
+	return stdin.Write(data)
+}
+
+// Stops the encoder.  The HTTP server keeps running, and listeners stay connected.
+func (self *HttpdOutput) Close() error {
+	self.lock.Lock()
+	proc := self.proc
+	stdin := self.stdin
+	done := self.done
+
+	self.proc = nil
+	self.stdin = nil
+	self.lock.Unlock()
+
+	if proc == nil {
+		return nil
+	}
+
+	stdin.Close()
+	<-done
+
+	return proc.Wait()
+}
+
+// Updates the stream title sent to ICY-aware listeners.
+func (self *HttpdOutput) SongChanged(entry *library.Entry) {
+	var title string
+
+	if entry != nil {
+		if artist := entry.Metadata.Artist; artist != `` {
+			title = artist + ` - `
+		}
+
+		if name := entry.Metadata.Title; name != `` {
+			title += name
+		} else {
+			title += path.Base(entry.Path)
+		}
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.title = title
+}
+
+func (self *HttpdOutput) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	self.lock.Lock()
+
+	if self.config.MaxClients > 0 && len(self.listeners) >= self.config.MaxClients {
+		self.lock.Unlock()
+		http.Error(w, `Too many listeners`, http.StatusServiceUnavailable)
+		return
+	}
+
+	listener := &httpdListener{
+		chunks: make(chan []byte, HttpdListenerBacklog),
+	}
+
+	// listeners joining mid-stream need the stream header before anything else
+	if self.hasHeader && len(self.header) > 0 {
+		listener.chunks <- self.header
+	}
+
+	self.listeners[listener] = true
+	self.lock.Unlock()
+
+	defer func() {
+		self.lock.Lock()
+		self.drop(listener)
+		self.lock.Unlock()
+	}()
+
+	metaint := 0
+
+	if req.Header.Get(`Icy-MetaData`) == `1` {
+		metaint = HttpdMetadataInterval
+		w.Header().Set(`icy-metaint`, fmt.Sprintf("%d", metaint))
+	}
+
+	w.Header().Set(`Content-Type`, self.encoder.ContentType)
+	w.Header().Set(`Cache-Control`, `no-cache, no-store`)
+	w.Header().Set(`icy-name`, self.config.Name)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	// send the headers now, since there may be no audio for a while (e.g.: while stopped)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	sinceMeta := 0
+	lastTitle := ``
+
+	for {
+		select {
+		case chunk, ok := <-listener.chunks:
+			if !ok {
+				return
+			}
+
+			for len(chunk) > 0 {
+				n := len(chunk)
+
+				if metaint > 0 && sinceMeta+n > metaint {
+					n = metaint - sinceMeta
+				}
+
+				if _, err := w.Write(chunk[:n]); err != nil {
+					return
+				}
+
+				chunk = chunk[n:]
+				sinceMeta += n
+
+				if metaint > 0 && sinceMeta == metaint {
+					self.lock.Lock()
+					title := self.title
+					self.lock.Unlock()
+
+					var block []byte
+
+					if title != lastTitle {
+						block = icyMetadataBlock(title)
+						lastTitle = title
+					} else {
+						block = []byte{0}
+					}
+
+					if _, err := w.Write(block); err != nil {
+						return
+					}
+
+					sinceMeta = 0
+				}
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (self *HttpdOutput) listen() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.listener != nil {
+		return nil
+	}
+
+	if listener, err := net.Listen(`tcp`, self.config.Address); err == nil {
+		self.listener = listener
+		log.Infof("HTTP stream listening on %v", listener.Addr())
+
+		go func() {
+			if err := http.Serve(listener, self); err != nil {
+				log.Errorf("HTTP stream server stopped: %v", err)
+			}
+		}()
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// reads the encoder's output and sends it to every listener
+func (self *HttpdOutput) broadcast(reader *bufio.Reader, done chan struct{}) {
+	defer close(done)
+
+	var header []byte
+	var err error
+
+	switch self.config.Encoder {
+	case `flac`:
+		header, err = readFlacHeader(reader)
+	case `opus`:
+		header, err = readOggHeader(reader)
+	}
+
+	if err != nil {
+		if err != io.EOF {
+			log.Warningf("httpd: failed to read stream header: %v", err)
+		}
+
+		io.Copy(ioutil.Discard, reader)
+		return
+	}
+
+	if len(header) > 0 {
+		self.lock.Lock()
+
+		// every encoder produces the same FLAC header (since the format never changes), which
+		// listeners already have; Ogg streams, on the other hand, are chained together, with each
+		// one starting with its own header
+		if self.config.Encoder == `opus` || !bytes.Equal(header, self.header) {
+			self.header = header
+			self.hasHeader = true
+
+			for listener := range self.listeners {
+				self.send(listener, header)
+			}
+		}
+
+		self.lock.Unlock()
+	}
+
+	for {
+		var chunk []byte
+
+		if self.config.Encoder == `opus` {
+			// send whole Ogg pages, so that listeners always join on a page boundary
+			chunk, _, err = readOggPage(reader)
+		} else {
+			buf := make([]byte, 4096)
+			var n int
+
+			n, err = reader.Read(buf)
+			chunk = buf[:n]
+		}
+
+		if len(chunk) > 0 {
+			self.lock.Lock()
+
+			for listener := range self.listeners {
+				self.send(listener, chunk)
+			}
+
+			self.lock.Unlock()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// must be called with the lock held
+func (self *HttpdOutput) send(listener *httpdListener, chunk []byte) {
+	if listener.closed {
+		return
+	}
+
+	select {
+	case listener.chunks <- chunk:
+	default:
+		log.Warningf("httpd: disconnecting listener that can't keep up")
+		self.drop(listener)
+	}
+}
+
+// must be called with the lock held
+func (self *HttpdOutput) drop(listener *httpdListener) {
+	if !listener.closed {
+		listener.closed = true
+		close(listener.chunks)
+	}
+
+	delete(self.listeners, listener)
+}
+
+// returns the ffmpeg sample format that the FLAC encoder uses for the given bit depth
+func flacSampleFormat(bits int) string {
+	if bits > 16 {
+		return `s32`
+	}
+
+	return `s16`
+}
+
+func icyMetadataBlock(title string) []byte {
+	meta := fmt.Sprintf("StreamTitle='%s';", strings.Replace(title, `'`, `’`, -1))
+
+	// the block is prefixed with its length in units of 16 bytes (which can't exceed 255)
+	if len(meta) > 255*16 {
+		meta = meta[:255*16]
+	}
+
+	blocks := (len(meta) + 15) / 16
+	out := make([]byte, 1+blocks*16)
+	out[0] = byte(blocks)
+	copy(out[1:], meta)
+
+	return out
+}
+
+// reads the "fLaC" marker and all metadata blocks that precede the first audio frame
+func readFlacHeader(reader *bufio.Reader) ([]byte, error) {
+	header := bytes.NewBuffer(nil)
+
+	if _, err := io.CopyN(header, reader, 4); err != nil {
+		return nil, err
+	} else if header.String() != `fLaC` {
+		return nil, fmt.Errorf("not a FLAC stream")
+	}
+
+	for {
+		blockHeader := make([]byte, 4)
+
+		if _, err := io.ReadFull(reader, blockHeader); err != nil {
+			return nil, err
+		}
+
+		header.Write(blockHeader)
+		length := int64(blockHeader[1])<<16 | int64(blockHeader[2])<<8 | int64(blockHeader[3])
+
+		if _, err := io.CopyN(header, reader, length); err != nil {
+			return nil, err
+		}
+
+		// the high bit of the block header marks the last metadata block
+		if blockHeader[0]&0x80 != 0 {
+			return header.Bytes(), nil
+		}
+	}
+}
+
+// reads the Ogg pages that make up the codec headers (which all have a granule position of zero)
+func readOggHeader(reader *bufio.Reader) ([]byte, error) {
+	header := bytes.NewBuffer(nil)
+
+	for {
+		if peek, err := reader.Peek(14); err == nil {
+			if granule := binary.LittleEndian.Uint64(peek[6:14]); granule != 0 && header.Len() > 0 {
+				return header.Bytes(), nil
+			}
+		} else {
+			return nil, err
+		}
+
+		if page, _, err := readOggPage(reader); err == nil {
+			header.Write(page)
+		} else {
+			return nil, err
+		}
+	}
+}
+
+func readOggPage(reader *bufio.Reader) ([]byte, uint64, error) {
+	head := make([]byte, 27)
+
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, 0, err
+	} else if string(head[0:4]) != `OggS` {
+		return nil, 0, fmt.Errorf("lost Ogg page sync")
+	}
+
+	segments := make([]byte, int(head[26]))
+
+	if _, err := io.ReadFull(reader, segments); err != nil {
+		return nil, 0, err
+	}
+
+	var size int
+
+	for _, segment := range segments {
+		size += int(segment)
+	}
+
+	body := make([]byte, size)
+
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, 0, err
+	}
+
+	page := make([]byte, 0, len(head)+len(segments)+len(body))
+	page = append(page, head...)
+	page = append(page, segments...)
+	page = append(page, body...)
+
+	return page, binary.LittleEndian.Uint64(head[6:14]), nil
+}
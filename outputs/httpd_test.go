@@ -0,0 +1,168 @@
+package outputs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+// an MP3 "encoder" that records its arguments and passes audio through untouched, so that what
+// listeners receive can be compared with what was written
+const fakeEncoder = "#!/bin/sh\necho \"$@\" >> %s\nexec cat\n"
+
+func TestHttpdOutputKeepsListenersAcrossFormats(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `moped-httpd-output`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	argsFile := filepath.Join(dir, `args`)
+	encoder := filepath.Join(dir, `ffmpeg`)
+
+	if err := ioutil.WriteFile(encoder, []byte(fmt.Sprintf(fakeEncoder, argsFile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(name string) {
+		audio.FFmpegCommandName = name
+	}(audio.FFmpegCommandName)
+
+	audio.FFmpegCommandName = encoder
+
+	output, err := NewHttpdOutput(&HttpdConfig{
+		Address: `127.0.0.1:0`,
+		Encoder: `mp3`,
+		Bitrate: 192,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// open (and close) the output once so that it starts listening, then connect
+	if err := output.Open(cdFormat); err != nil {
+		t.Fatal(err)
+	} else if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(fmt.Sprintf("http://%v/", output.Addr()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	received := make(chan []byte)
+	ended := make(chan error, 1)
+
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := response.Body.Read(buf)
+
+			if n > 0 {
+				received <- buf[:n]
+			}
+
+			if err != nil {
+				ended <- err
+				return
+			}
+		}
+	}()
+
+	// wait for the listener to be registered before playing anything
+	for i := 0; i < 100; i++ {
+		output.lock.Lock()
+		count := len(output.listeners)
+		output.lock.Unlock()
+
+		if count > 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	songs := []fileWrite{
+		{cdFormat, bytes.Repeat([]byte{'a'}, 10000)},
+		{dvdFormat, bytes.Repeat([]byte{'b'}, 12000)},
+	}
+
+	want := make([]byte, 0)
+
+	for _, song := range songs {
+		if err := output.Open(song.format); err != nil {
+			t.Fatal(err)
+		}
+
+		if n, err := output.Write(song.data); err != nil || n != len(song.data) {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+
+		if err := output.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, song.data...)
+	}
+
+	got := make([]byte, 0)
+	timeout := time.After(5 * time.Second)
+
+	for len(got) < len(want) {
+		select {
+		case chunk := <-received:
+			got = append(got, chunk...)
+		case err := <-ended:
+			t.Fatalf("stream ended after %d of %d bytes: %v", len(got), len(want), err)
+		case <-timeout:
+			t.Fatalf("received %d of %d bytes", len(got), len(want))
+		}
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("listener received %d bytes that differ from the %d bytes played", len(got), len(want))
+	}
+
+	// the output is stopped now, but the stream should stay open
+	select {
+	case chunk := <-received:
+		t.Errorf("received %d bytes after playback stopped", len(chunk))
+	case err := <-ended:
+		t.Errorf("stream ended after playback stopped: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// every encoder should produce the same output format, whatever the input format
+	args, err := ioutil.ReadFile(argsFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("encoder was started %d times, want 3", len(lines))
+	}
+
+	for i, line := range lines {
+		if !strings.Contains(line, `-i pipe:0 -ar 44100 -ac 2 `) {
+			t.Errorf("encoder %d was not started with the stream format: %v", i, line)
+		}
+	}
+}
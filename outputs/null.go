@@ -0,0 +1,54 @@
+package outputs
+
+import (
+	"time"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+func init() {
+	Register(`null`, func(config map[string]interface{}) (Output, error) {
+		return NewNullOutput(), nil
+	})
+}
+
+// The NullOutput discards all audio written to it, but does so at the same pace a real sound
+// card would consume it.
+type NullOutput struct {
+	format  audio.Format
+	started time.Time
+	written int64
+}
+
+func NewNullOutput() *NullOutput {
+	return new(NullOutput)
+}
+
+func (self *NullOutput) Open(format audio.Format) error {
+	self.format = format
+	self.started = time.Now()
+	self.written = 0
+
+	return nil
+}
+
+func (self *NullOutput) Write(data []byte) (int, error) {
+	// if we've fallen behind the clock (e.g.: because playback was paused), start counting again
+	// from now rather than racing to catch up
+	if time.Since(self.started)-self.format.Duration(self.written) > time.Second {
+		self.started = time.Now()
+		self.written = 0
+	}
+
+	self.written += int64(len(data))
+
+	if ahead := time.Until(self.started.Add(self.format.Duration(self.written))); ahead > 0 {
+		time.Sleep(ahead)
+	}
+
+	return len(data), nil
+}
+
+func (self *NullOutput) Close() error {
+	return nil
+}
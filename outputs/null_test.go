@@ -0,0 +1,57 @@
+package outputs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+func TestNullOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   audio.Format
+		duration time.Duration
+		chunks   int
+	}{
+		{
+			name:     `cd`,
+			format:   cdFormat,
+			duration: 200 * time.Millisecond,
+			chunks:   4,
+		}, {
+			name:     `dvd`,
+			format:   dvdFormat,
+			duration: 300 * time.Millisecond,
+			chunks:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := NewNullOutput()
+
+			if err := output.Open(tt.format); err != nil {
+				t.Fatal(err)
+			}
+
+			chunk := make([]byte, tt.format.Bytes(tt.duration/time.Duration(tt.chunks)))
+			started := time.Now()
+
+			for i := 0; i < tt.chunks; i++ {
+				if n, err := output.Write(chunk); err != nil || n != len(chunk) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+
+			// audio is consumed in real time, so writing it takes (about) as long as it plays for
+			if elapsed := time.Since(started); elapsed < tt.duration*3/4 {
+				t.Errorf("wrote %v of audio in %v", tt.duration, elapsed)
+			}
+
+			if err := output.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package outputs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+)
+
+// An Output is a sink that raw PCM audio is written to.
+type Output interface {
+	// Prepares the output to receive audio in the given format.
+	Open(format audio.Format) error
+
+	// Writes a chunk of PCM audio (always a whole number of frames) to the output.
+	Write(data []byte) (int, error)
+
+	// Flushes and releases any resources held by the output.  The output may be opened again later.
+	Close() error
+}
+
+// Outputs that want to know which song is being played implement this interface.
+type SongAware interface {
+	SongChanged(entry *library.Entry)
+}
+
+// A Factory creates a new Output from the "config" section of an output's configuration.
+type Factory func(config map[string]interface{}) (Output, error)
+
+var factories = make(map[string]Factory)
+var factoryLock sync.RWMutex
+
+// Registers a factory for creating outputs of the given type.  It panics if the factory is nil, or
+// if a factory has already been registered for the type.
+func Register(outputType string, factory Factory) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+
+	if factory == nil {
+		panic(`outputs: Register factory is nil`)
+	} else if _, dup := factories[outputType]; dup {
+		panic(`outputs: Register called twice for type ` + outputType)
+	}
+
+	factories[outputType] = factory
+}
+
+// Returns the names of all registered output types.
+func Types() []string {
+	factoryLock.RLock()
+	defer factoryLock.RUnlock()
+
+	types := maputil.StringKeys(factories)
+	sort.Strings(types)
+
+	return types
+}
+
+// Creates a new output of the given type.
+func New(outputType string, config map[string]interface{}) (Output, error) {
+	factoryLock.RLock()
+	factory, ok := factories[outputType]
+	factoryLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf(
+			"Unknown output type %q (valid types are: %s)",
+			outputType,
+			strings.Join(Types(), `, `),
+		)
+	}
+
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+
+	return factory(config)
+}
+
+func configure(config map[string]interface{}, into interface{}) error {
+	return maputil.TaggedStructFromMap(config, into, `json`)
+}
@@ -0,0 +1,111 @@
+package outputs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ghetzel/moped/audio"
+	"github.com/kballard/go-shellquote"
+)
+
+func init() {
+	Register(`pipe`, func(config map[string]interface{}) (Output, error) {
+		var cfg PipeConfig
+
+		if err := configure(config, &cfg); err != nil {
+			return nil, err
+		}
+
+		return NewPipeOutput(&cfg)
+	})
+}
+
+type PipeConfig struct {
+	// The command to run.  The strings {samplerate}, {bits}, and {channels} are replaced with
+	// the details of the audio being written to it.
+	Command string `json:"command"`
+}
+
+// The PipeOutput runs a command and writes raw PCM audio to its standard input.
+type PipeOutput struct {
+	config *PipeConfig
+	args   []string
+	proc   *exec.Cmd
+	stdin  io.WriteCloser
+}
+
+func NewPipeOutput(config *PipeConfig) (*PipeOutput, error) {
+	if config == nil || config.Command == `` {
+		return nil, fmt.Errorf("Must specify a command for a pipe output")
+	}
+
+	if args, err := shellquote.Split(config.Command); err == nil {
+		return &PipeOutput{
+			config: config,
+			args:   args,
+		}, nil
+	} else {
+		return nil, fmt.Errorf("Invalid pipe command: %v", err)
+	}
+}
+
+func (self *PipeOutput) Open(format audio.Format) error {
+	replacer := strings.NewReplacer(
+		`{samplerate}`, fmt.Sprintf("%d", format.SampleRate),
+		`{bits}`, fmt.Sprintf("%d", format.Bits),
+		`{channels}`, fmt.Sprintf("%d", format.Channels),
+	)
+
+	args := make([]string, len(self.args))
+
+	for i, arg := range self.args {
+		args[i] = replacer.Replace(arg)
+	}
+
+	proc := exec.Command(args[0], args[1:]...)
+	proc.Stdout = os.Stdout
+	proc.Stderr = os.Stderr
+	proc.Env = append(os.Environ(),
+		fmt.Sprintf("MOPED_SAMPLERATE=%d", format.SampleRate),
+		fmt.Sprintf("MOPED_BITS=%d", format.Bits),
+		fmt.Sprintf("MOPED_CHANNELS=%d", format.Channels),
+	)
+
+	if stdin, err := proc.StdinPipe(); err == nil {
+		self.stdin = stdin
+	} else {
+		return err
+	}
+
+	if err := proc.Start(); err != nil {
+		return err
+	}
+
+	self.proc = proc
+	return nil
+}
+
+func (self *PipeOutput) Write(data []byte) (int, error) {
+	if self.stdin == nil {
+		return 0, fmt.Errorf("pipe output is not open")
+	}
+
+	return self.stdin.Write(data)
+}
+
+func (self *PipeOutput) Close() error {
+	if self.proc == nil {
+		return nil
+	}
+
+	self.stdin.Close()
+	err := self.proc.Wait()
+
+	self.proc = nil
+	self.stdin = nil
+
+	return err
+}
@@ -0,0 +1,83 @@
+package outputs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghetzel/moped/audio"
+)
+
+func TestPipeOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		format  audio.Format
+		writes  [][]byte
+		want    string
+	}{
+		{
+			name:    `stdin`,
+			command: `sh -c 'cat > {out}'`,
+			format:  cdFormat,
+			writes:  [][]byte{[]byte(`abcd`), []byte(`efgh`)},
+			want:    `abcdefgh`,
+		}, {
+			name:    `placeholders`,
+			command: `sh -c 'echo {samplerate} {bits} {channels} > {out}; cat > /dev/null'`,
+			format:  dvdFormat,
+			writes:  [][]byte{[]byte(`abcdef`)},
+			want:    "48000 24 2\n",
+		}, {
+			name:    `environment`,
+			command: `sh -c 'echo $MOPED_SAMPLERATE $MOPED_BITS $MOPED_CHANNELS > {out}; cat > /dev/null'`,
+			format:  cdFormat,
+			writes:  [][]byte{[]byte(`abcd`)},
+			want:    "44100 16 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(``, `moped-pipe-output`)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			filename := filepath.Join(dir, `out`)
+			output, err := NewPipeOutput(&PipeConfig{
+				Command: strings.Replace(tt.command, `{out}`, filename, -1),
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := output.Open(tt.format); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, data := range tt.writes {
+				if n, err := output.Write(data); err != nil || n != len(data) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+
+			// closing waits for the command to exit, so everything has been written by then
+			if err := output.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := ioutil.ReadFile(filename); err != nil {
+				t.Fatal(err)
+			} else if string(got) != tt.want {
+				t.Errorf("command received %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package moped
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/outputs"
+)
+
+// The name of the partition that every client starts out in, and that every output belongs to
+// until it is moved elsewhere.  It always exists.
+const DefaultPartition = `default`
+
+var rxPartitionName = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// A Partition is an independent player (e.g.: one per room), with its own queue, playback state,
+// options and volume, which plays to its own set of outputs.  Every client is in exactly one
+// partition at a time, and the playback commands it sends apply to that partition.  Everything
+// else (libraries, the database, stored playlists, stickers, etc.) is shared by all of them.
+type Partition struct {
+	*Moped
+	Name         string
+	queue        *Queue
+	player       *Player
+	mixer        *Mixer
+	playmode     Playmode
+	playmodeLock sync.RWMutex
+	outputs      *outputs.Devices
+}
+
+func NewPartition(app *Moped, name string) *Partition {
+	partition := &Partition{
+		Moped:   app,
+		Name:    name,
+		outputs: outputs.NewDevices(),
+	}
+
+	partition.queue = NewQueue(partition)
+	partition.player = NewPlayer(partition, partition.queue)
+	partition.player.SetRenderer(NewPipeline(partition))
+	partition.mixer = NewMixer(partition)
+
+	return partition
+}
+
+// Notifies the clients in this partition that the given subsystem has changed.
+func (self *Partition) AddChangedSubsystem(subsystem string) {
+	self.clients.Range(func(id interface{}, clientI interface{}) bool {
+		if client := clientI.(*Client); client.Partition() == self {
+			client.AddChangedSubsystem(subsystem)
+		}
+
+		return true
+	})
+}
+
+// Returns the number of clients currently in this partition.
+func (self *Partition) ClientCount() int {
+	var count int
+
+	self.clients.Range(func(id interface{}, clientI interface{}) bool {
+		if clientI.(*Client).Partition() == self {
+			count++
+		}
+
+		return true
+	})
+
+	return count
+}
+
+// Returns the partition with the given name.
+func (self *Moped) Partition(name string) (*Partition, bool) {
+	self.partitionLock.RLock()
+	defer self.partitionLock.RUnlock()
+
+	partition, ok := self.partitions[name]
+	return partition, ok
+}
+
+// Returns the partition that clients start out in.
+func (self *Moped) DefaultPartition() *Partition {
+	partition, _ := self.Partition(DefaultPartition)
+	return partition
+}
+
+// Returns all partitions, sorted by name.
+func (self *Moped) Partitions() []*Partition {
+	self.partitionLock.RLock()
+	defer self.partitionLock.RUnlock()
+
+	partitions := make([]*Partition, 0, len(self.partitions))
+
+	for _, partition := range self.partitions {
+		partitions = append(partitions, partition)
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Name < partitions[j].Name
+	})
+
+	return partitions
+}
+
+// Creates a new (empty) partition.  New partitions start out with no outputs, and with the random
+// order and ReplayGain mode of the default partition (which come from the configuration).
+func (self *Moped) CreatePartition(name string) (*Partition, error) {
+	if !rxPartitionName.MatchString(name) {
+		return nil, fmt.Errorf("Invalid partition name %q", name)
+	}
+
+	partition := NewPartition(self, name)
+
+	if def := self.DefaultPartition(); def != nil {
+		mode := def.Playmode()
+		partition.playmode.RandomOrder = mode.RandomOrder
+		partition.playmode.ReplayGainMode = mode.ReplayGainMode
+	}
+
+	self.partitionLock.Lock()
+
+	if _, ok := self.partitions[name]; ok {
+		self.partitionLock.Unlock()
+		return nil, fmt.Errorf("Partition already exists")
+	}
+
+	self.partitions[name] = partition
+	self.partitionLock.Unlock()
+
+	log.Debugf("Created partition %v", name)
+	self.AddChangedSubsystem(`partition`)
+	self.saveStateOrWarn()
+
+	return partition, nil
+}
+
+// Deletes a partition, which must not be the default one and must have no clients or outputs.
+func (self *Moped) DeletePartition(name string) error {
+	if name == DefaultPartition {
+		return fmt.Errorf("Cannot delete the default partition")
+	}
+
+	partition, err := self.removePartition(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := partition.player.Stop(); err != nil {
+		log.Warningf("Failed to stop partition %v: %v", name, err)
+	}
+
+	log.Debugf("Deleted partition %v", name)
+	self.AddChangedSubsystem(`partition`)
+	self.saveStateOrWarn()
+
+	return nil
+}
+
+// removes the named partition if it has no clients or outputs.  Clients move into partitions while
+// holding the partition lock too, so none can join it between checking that it's empty and
+// removing it.
+func (self *Moped) removePartition(name string) (*Partition, error) {
+	self.partitionLock.Lock()
+	defer self.partitionLock.Unlock()
+
+	partition, ok := self.partitions[name]
+
+	if !ok {
+		return nil, fmt.Errorf("No such partition")
+	} else if partition.ClientCount() > 0 {
+		return nil, fmt.Errorf("Partition still has clients")
+	} else if len(partition.outputs.List()) > 0 {
+		return nil, fmt.Errorf("Partition still has outputs")
+	}
+
+	delete(self.partitions, name)
+	return partition, nil
+}
+
+// Moves the named output to the given partition, taking it away from whichever one it was in.
+func (self *Moped) MoveOutput(name string, to *Partition) error {
+	device, ok := self.outputs.GetByName(name)
+
+	if !ok {
+		return fmt.Errorf("No such audio output")
+	}
+
+	for _, partition := range self.Partitions() {
+		if partition == to {
+			continue
+		}
+
+		if _, ok := partition.outputs.Remove(name); ok {
+			// the output is reopened by its new partition once that has something to play
+			if err := device.Close(); err != nil {
+				log.Warningf("Failed to close output %q: %v", name, err)
+			}
+
+			partition.AddChangedSubsystem(`output`)
+		}
+	}
+
+	if _, ok := to.outputs.GetByName(name); !ok {
+		if err := to.outputs.Attach(device); err != nil {
+			return err
+		}
+
+		to.AddChangedSubsystem(`output`)
+		self.saveStateOrWarn()
+	}
+
+	return nil
+}
+
+// Adapts a handler for commands that act on a partition, so that they act on the partition of
+// the client that sent them.
+func (self *Moped) partitioned(handler func(*Partition, *cmd) *reply) cmdHandler {
+	return func(c *cmd) *reply {
+		return handler(self.clientPartition(c.Client), c)
+	}
+}
+
+func (self *Moped) clientPartition(client *Client) *Partition {
+	if client != nil {
+		if partition := client.Partition(); partition != nil {
+			return partition
+		}
+	}
+
+	return self.DefaultPartition()
+}
@@ -0,0 +1,517 @@
+package moped
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+)
+
+// how much audio is decoded and written to the outputs at a time
+var PipelineChunkSize = 100 * time.Millisecond
+
+// how far ahead of real time the pipeline is allowed to run
+var PipelineLead = 500 * time.Millisecond
+
+// The Pipeline is the Renderer that decodes queue items into PCM audio and writes it to every
+// enabled output device.  The song that comes next is decoded ahead of time, so that songs follow
+// one another without a gap (or are crossfaded, as per the playback options).
+type Pipeline struct {
+	app     *Partition
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	paused  bool
+	resume  chan struct{}
+	format  audio.Format
+	next    *track
+	scratch []byte
+	lock    sync.Mutex
+}
+
+// a queue item that is being decoded
+type track struct {
+	item     *QueueItem
+	entry    *library.Entry
+	stream   *audio.Stream
+	cancel   context.CancelFunc
+	advanced func()
+}
+
+func (self *track) Close() {
+	self.cancel()
+	self.stream.Close()
+	self.entry.Close()
+}
+
+func NewPipeline(app *Partition) *Pipeline {
+	return &Pipeline{
+		app: app,
+	}
+}
+
+func (self *Pipeline) Play(item *QueueItem, offset time.Duration, finished func()) error {
+	self.halt()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	current, err := self.open(ctx, item, offset, self.app.AudioFormat)
+
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	self.announce(current)
+
+	done := make(chan struct{})
+
+	self.lock.Lock()
+	self.ctx = ctx
+	self.cancel = cancel
+	self.done = done
+	self.paused = false
+	self.format = current.stream.Format
+	self.lock.Unlock()
+
+	go self.run(ctx, current, finished, done)
+
+	return nil
+}
+
+// Sets the song to move on to once the current one ends, and starts decoding it right away so that
+// it's ready in time.
+func (self *Pipeline) SetNext(item *QueueItem, advanced func()) error {
+	self.lock.Lock()
+	previous := self.next
+	ctx := self.ctx
+	format := self.format
+	running := (self.cancel != nil)
+
+	if previous != nil && previous.item == item {
+		previous.advanced = advanced
+		self.lock.Unlock()
+		return nil
+	}
+
+	self.next = nil
+	self.lock.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+
+	if item == nil || !running {
+		return nil
+	}
+
+	// songs can only be crossfaded if they're in the same format, so when crossfading is on, the
+	// next song is decoded in the format of the current one
+	want := self.app.AudioFormat
+
+	if self.app.Playmode().Crossfade > 0 {
+		want = format
+	}
+
+	next, err := self.open(ctx, item, item.Start, want)
+
+	if err != nil {
+		return err
+	}
+
+	next.advanced = advanced
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// playback was stopped while we were getting ready
+	if self.ctx != ctx {
+		next.Close()
+		return nil
+	}
+
+	self.next = next
+	return nil
+}
+
+func (self *Pipeline) Pause() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.paused {
+		self.paused = true
+		self.resume = make(chan struct{})
+	}
+
+	return nil
+}
+
+func (self *Pipeline) Resume() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.paused {
+		self.paused = false
+		close(self.resume)
+	}
+
+	return nil
+}
+
+func (self *Pipeline) Stop() error {
+	self.halt()
+
+	for _, device := range self.app.outputs.List() {
+		if err := device.Close(); err != nil {
+			log.Warningf("Failed to close output %q: %v", device.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// stops decoding (but leaves the outputs open)
+func (self *Pipeline) halt() {
+	self.lock.Lock()
+	cancel := self.cancel
+	done := self.done
+	next := self.next
+
+	self.ctx = nil
+	self.cancel = nil
+	self.done = nil
+	self.next = nil
+
+	if self.paused {
+		self.paused = false
+		close(self.resume)
+	}
+
+	self.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	if next != nil {
+		next.Close()
+	}
+}
+
+// starts decoding the given item
+func (self *Pipeline) open(ctx context.Context, item *QueueItem, offset time.Duration, format audio.Format) (*track, error) {
+	var entry *library.Entry
+
+	if item.IsRemote() {
+		// URLs aren't in any library; the decoder opens them itself
+		entry = library.NewRemoteEntry(item.Path)
+		entry.Metadata = item.Metadata
+	} else if e, err := self.app.Get(item.FullPath()); err == nil {
+		// retrieve a fresh copy of the entry so that we have a data source all to ourselves
+		entry = e
+	} else {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	stream, err := audio.Decode(ctx, entry, audio.DecodeOptions{
+		Format: audio.Negotiate(audio.FormatOfEntry(entry), format),
+		Offset: offset,
+		End:    item.End,
+	})
+
+	if err != nil {
+		cancel()
+		entry.Close()
+		return nil, err
+	}
+
+	return &track{
+		item:   item,
+		entry:  entry,
+		stream: stream,
+		cancel: cancel,
+	}, nil
+}
+
+func (self *Pipeline) run(ctx context.Context, current *track, finished func(), done chan struct{}) {
+	// the song being faded out while crossfading, and how far along the crossfade is (in bytes)
+	var outgoing *track
+	var fade, faded int64
+	var mixramp bool
+
+	defer close(done)
+	defer func() {
+		current.Close()
+
+		if outgoing != nil {
+			outgoing.Close()
+		}
+	}()
+
+	format := current.stream.Format
+	buffer := make([]byte, format.Bytes(PipelineChunkSize))
+	fadebuf := make([]byte, len(buffer))
+	clock := time.Now()
+	var played time.Duration
+
+	for {
+		if self.waitIfPaused(ctx) {
+			clock = time.Now()
+			played = 0
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if outgoing == nil {
+			if next, overlap, add := self.crossfade(current); next != nil {
+				outgoing = current
+				current = next
+				fade = format.Bytes(overlap)
+				faded = 0
+				mixramp = add
+
+				self.advance(current, played-time.Since(clock))
+			}
+		}
+
+		n, err := io.ReadFull(current.stream, buffer)
+		audio.ApplyGain(format, buffer[:n], self.replayGain(current))
+
+		if outgoing != nil && n > 0 {
+			m, ferr := io.ReadFull(outgoing.stream, fadebuf[:n])
+			audio.ApplyGain(format, fadebuf[:m], self.replayGain(outgoing))
+
+			if mixramp {
+				audio.Mix(format, buffer[:n], fadebuf[:m])
+			} else {
+				audio.Crossfade(
+					format,
+					buffer[:n],
+					fadebuf[:m],
+					float64(faded)/float64(fade),
+					float64(faded+int64(n))/float64(fade),
+				)
+			}
+
+			faded += int64(n)
+
+			if ferr != nil || faded >= fade {
+				outgoing.Close()
+				outgoing = nil
+			}
+		}
+
+		if n > 0 {
+			self.write(format, buffer[:n])
+			played += format.Duration(int64(n))
+
+			// don't get too far ahead of real time, so that pause and stop take effect promptly
+			// and so that outputs that accept data as fast as we can give it (like files) still
+			// play at the right speed
+			if ahead := played - time.Since(clock); ahead > PipelineLead {
+				select {
+				case <-time.After(ahead - PipelineLead):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Errorf("Playback of %v failed: %v", current.item.FullPath(), err)
+			}
+
+			// move straight on to the next song if we have one ready
+			if next := self.takeNext(); next != nil {
+				current.Close()
+				current = next
+
+				if current.stream.Format != format {
+					format = current.stream.Format
+					buffer = make([]byte, format.Bytes(PipelineChunkSize))
+					fadebuf = make([]byte, len(buffer))
+				}
+
+				self.advance(current, played-time.Since(clock))
+				continue
+			}
+
+			finished()
+			return
+		}
+	}
+}
+
+func (self *Pipeline) takeNext() *track {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	next := self.next
+	self.next = nil
+
+	return next
+}
+
+// Decides whether it's time to start crossfading from the current song into the next one.  If so,
+// the next song is handed over along with how long the two should overlap for.  If mixramp is
+// true, the songs should simply be added together rather than faded into one another.
+func (self *Pipeline) crossfade(current *track) (*track, time.Duration, bool) {
+	mode := self.app.Playmode()
+
+	if mode.Crossfade <= 0 {
+		return nil, 0, false
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	next := self.next
+
+	if next == nil || next.stream.Format != current.stream.Format {
+		return nil, 0, false
+	}
+
+	length := itemLength(current.item)
+	overlap := time.Duration(mode.Crossfade) * time.Second
+	mixramp := false
+
+	// songs shorter than the crossfade (or of unknown length) aren't crossfaded
+	if length <= 0 || overlap >= length {
+		return nil, 0, false
+	}
+
+	if mode.MixRamp {
+		start := typeutil.String(next.entry.Metadata.Extra[`mixramp_start`])
+		end := typeutil.String(current.entry.Metadata.Extra[`mixramp_end`])
+
+		// without ramp data for both songs, we fall back to a regular crossfade
+		if start != `` && end != `` {
+			in := audio.MixRampInterpolate(start, mode.MixRampDB)
+			out := audio.MixRampInterpolate(end, mode.MixRampDB)
+
+			if in < 0 || out < 0 || in+out <= mode.MixRampDelay {
+				return nil, 0, false
+			}
+
+			overlap = time.Duration((in + out - mode.MixRampDelay) * float64(time.Second))
+			mixramp = true
+
+			// nor are songs shorter than their ramps
+			if overlap >= length {
+				return nil, 0, false
+			}
+		}
+	}
+
+	if current.stream.Position() < length-overlap {
+		return nil, 0, false
+	}
+
+	self.next = nil
+	return next, overlap, mixramp
+}
+
+// Switches over to a new song: the outputs are told right away, while the player is told once the
+// song can actually be heard (which is however far ahead of real time the pipeline is running).
+func (self *Pipeline) advance(next *track, delay time.Duration) {
+	self.lock.Lock()
+	self.format = next.stream.Format
+	self.lock.Unlock()
+
+	self.announce(next)
+
+	if delay > 0 {
+		time.AfterFunc(delay, next.advanced)
+	} else {
+		next.advanced()
+	}
+}
+
+// Returns the scale factor to apply to the given track, as per its ReplayGain values and the
+// current replay gain mode.
+func (self *Pipeline) replayGain(current *track) float64 {
+	mode := self.app.Playmode()
+	rgmode := mode.ReplayGainMode
+
+	switch rgmode {
+	case audio.ReplayGainOff, ``:
+		return 1
+	case audio.ReplayGainAuto:
+		// songs played in random order are normalized individually, while albums played in order
+		// keep the differences in loudness between their tracks
+		if mode.Random {
+			rgmode = audio.ReplayGainTrack
+		} else {
+			rgmode = audio.ReplayGainAlbum
+		}
+	}
+
+	return self.app.ReplayGain.Scale(audio.SelectReplayGain(current.entry.Metadata.ReplayGain, rgmode))
+}
+
+func (self *Pipeline) announce(current *track) {
+	for _, device := range self.app.outputs.List() {
+		device.SongChanged(current.entry)
+	}
+}
+
+// blocks for as long as playback is paused, returning whether it was
+func (self *Pipeline) waitIfPaused(ctx context.Context) bool {
+	self.lock.Lock()
+	paused := self.paused
+	resume := self.resume
+	self.lock.Unlock()
+
+	if paused {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+		}
+	}
+
+	return paused
+}
+
+func (self *Pipeline) write(format audio.Format, data []byte) {
+	gain := self.app.mixer.Gain()
+
+	for _, device := range self.app.outputs.Enabled() {
+		if err := device.Open(format); err != nil {
+			log.Warningf("%v", err)
+			continue
+		}
+
+		out := data
+
+		// apply the volume to a copy, since each device may have its own volume on top of the
+		// global one
+		if g := gain * audio.VolumeToGain(device.Volume()); g != 1 {
+			if cap(self.scratch) < len(data) {
+				self.scratch = make([]byte, len(data))
+			}
+
+			out = self.scratch[:len(data)]
+			copy(out, data)
+			audio.ApplyGain(format, out, g)
+		}
+
+		if _, err := device.Write(out); err != nil {
+			log.Warningf("Failed to write to output %q: %v", device.Name, err)
+			device.Close()
+		}
+	}
+}
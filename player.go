@@ -0,0 +1,770 @@
+package moped
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/moped/library"
+)
+
+type PlayerState int
+
+const (
+	StateStopped PlayerState = iota
+	StatePlaying
+	StatePaused
+)
+
+func (self PlayerState) String() string {
+	switch self {
+	case StatePlaying:
+		return `play`
+	case StatePaused:
+		return `pause`
+	default:
+		return `stop`
+	}
+}
+
+// A Renderer is the pluggable output stage driven by the Player; it is what actually turns queue
+// items into sound.  Once an item has played through to the end, the renderer must call the
+// finished function it was given (but not if playback was stopped or replaced by another call to
+// Play).  The finished function may be called from any goroutine.
+type Renderer interface {
+	Play(item *QueueItem, offset time.Duration, finished func()) error
+	Pause() error
+	Resume() error
+	Stop() error
+}
+
+// Renderers that can move from one song straight into the next, without the gap that comes from
+// stopping and starting again (and so can also crossfade between them), implement this interface.
+// The Player calls SetNext whenever the song that should follow the current one may have changed;
+// a nil item means that playback should end after the current song.  When the renderer moves on to
+// the next item it calls the advanced function given along with it (from any goroutine) instead of
+// calling finished.
+type GaplessRenderer interface {
+	Renderer
+	SetNext(item *QueueItem, advanced func()) error
+}
+
+// The Player is the playback state machine that sits between the queue and a Renderer.
+type Player struct {
+	app        *Partition
+	queue      *Queue
+	renderer   Renderer
+	state      PlayerState
+	item       *QueueItem
+	elapsed    time.Duration
+	resumedAt  time.Time
+	playtime   time.Duration
+	playingAt  time.Time
+	generation uint64
+	err        error
+	shuffled   []library.EntryID
+	shuffledAt uint32
+	shuffledBy library.EntryOrder
+	lock       sync.Mutex
+	prefetch   sync.Mutex
+}
+
+func NewPlayer(app *Partition, queue *Queue) *Player {
+	return &Player{
+		app:      app,
+		queue:    queue,
+		renderer: new(nullRenderer),
+	}
+}
+
+// Replaces the renderer used for playback.  Any currently-playing song is stopped first.
+func (self *Player) SetRenderer(renderer Renderer) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if err := self.stop(); err != nil {
+		return err
+	}
+
+	if renderer == nil {
+		renderer = new(nullRenderer)
+	}
+
+	self.renderer = renderer
+	return nil
+}
+
+func (self *Player) State() PlayerState {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.state
+}
+
+// Returns how long the player has spent playing (not counting time spent paused or stopped).
+func (self *Player) Playtime() time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state == StatePlaying {
+		return self.playtime + time.Since(self.playingAt)
+	}
+
+	return self.playtime
+}
+
+// Returns the position within the current song.
+func (self *Player) Elapsed() time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.position()
+}
+
+// Returns the length of the current song, or zero if it is not known.
+func (self *Player) Duration() time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.item != nil {
+		return itemLength(self.item)
+	}
+
+	return 0
+}
+
+// Returns the last error encountered during playback, if any.
+func (self *Player) Error() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.err
+}
+
+func (self *Player) ClearError() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.err = nil
+}
+
+// Returns the song that will be played after the current one, according to the playback options.
+func (self *Player) Peek() (*QueueItem, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.peek(false)
+}
+
+// Starts playback.  If paused, playback is resumed; otherwise the current song (or the first song
+// in the queue, if there is no current song) is played from the beginning.
+func (self *Player) Play() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	switch self.state {
+	case StatePaused:
+		return self.resume()
+	case StatePlaying:
+		return nil
+	}
+
+	if current, ok := self.queue.Current(); ok {
+		return self.start(current, 0)
+	} else if first, ok := self.queue.Get(0); ok {
+		return self.start(first, 0)
+	} else {
+		return nil
+	}
+}
+
+// Starts playing the song at the given queue position.
+func (self *Player) PlayPosition(pos int) error {
+	if item, ok := self.queue.Get(pos); ok {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+
+		return self.start(item, 0)
+	} else {
+		return fmt.Errorf("Bad song index")
+	}
+}
+
+// Starts playing the song with the given song ID.
+func (self *Player) PlayID(id library.EntryID) error {
+	if item, ok := self.queue.GetID(id); ok {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+
+		return self.start(item, 0)
+	} else {
+		return fmt.Errorf("No such song")
+	}
+}
+
+// Pauses (or, if pause is false, resumes) playback.
+func (self *Player) Pause(pause bool) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if pause {
+		return self.pause()
+	} else {
+		return self.resume()
+	}
+}
+
+// Toggles between the playing and paused states.
+func (self *Player) TogglePause() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	switch self.state {
+	case StatePlaying:
+		return self.pause()
+	case StatePaused:
+		return self.resume()
+	default:
+		return nil
+	}
+}
+
+func (self *Player) Stop() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.stop()
+}
+
+// Skips to the next song in the queue.
+func (self *Player) Next() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state == StateStopped {
+		return nil
+	}
+
+	previous := self.item
+	var err error
+
+	if next, ok := self.peek(true); ok {
+		err = self.start(next, 0)
+	} else {
+		err = self.stop()
+	}
+
+	self.consume(previous)
+	return err
+}
+
+// Skips to the previous song in the queue (or in the random order, in random mode), or to the
+// start of the current song if it is the first one.
+func (self *Player) Previous() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state == StateStopped {
+		return nil
+	}
+
+	order := self.order(self.app.Playmode())
+
+	if pos := indexOf(order, self.item); pos > 0 {
+		return self.start(order[pos-1], 0)
+	}
+
+	if self.item != nil {
+		return self.start(self.item, 0)
+	}
+
+	return nil
+}
+
+// Seeks to the given offset within the song at the given queue position, starting playback if
+// necessary.
+func (self *Player) Seek(pos int, offset time.Duration) error {
+	if item, ok := self.queue.Get(pos); ok {
+		return self.seek(item, offset)
+	} else {
+		return fmt.Errorf("Bad song index")
+	}
+}
+
+// Seeks to the given offset within the song with the given song ID, starting playback if
+// necessary.
+func (self *Player) SeekID(id library.EntryID, offset time.Duration) error {
+	if item, ok := self.queue.GetID(id); ok {
+		return self.seek(item, offset)
+	} else {
+		return fmt.Errorf("No such song")
+	}
+}
+
+// Seeks within the current song.  If relative is true, the offset is added to the current
+// position rather than being treated as an absolute position.
+func (self *Player) SeekCurrent(offset time.Duration, relative bool) error {
+	self.lock.Lock()
+	item := self.item
+	state := self.state
+
+	if relative {
+		offset += self.position()
+	}
+
+	self.lock.Unlock()
+
+	if item == nil || state == StateStopped {
+		return fmt.Errorf("Not playing")
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	return self.seek(item, offset)
+}
+
+// Reconciles the player with the queue after it has been modified; if the song being played was
+// removed, playback continues with whatever is now the current song (or stops if there is none).
+func (self *Player) Sync() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state == StateStopped {
+		return nil
+	}
+
+	if current, ok := self.queue.Current(); !ok {
+		return self.stop()
+	} else if current != self.item {
+		return self.start(current, 0)
+	}
+
+	return nil
+}
+
+// Tells the renderer about any change to what should be played after the current song; this
+// should be called whenever the queue or the playback options change.  Getting the next song ready
+// can take a while (a gapless renderer starts decoding it), so this is done without holding the
+// player lock; if what should come next changes in the meantime, it is done again.
+func (self *Player) Refresh() {
+	self.prefetch.Lock()
+	defer self.prefetch.Unlock()
+
+	for {
+		self.lock.Lock()
+		gapless, ok := self.renderer.(GaplessRenderer)
+
+		if !ok || self.state == StateStopped {
+			self.lock.Unlock()
+			return
+		}
+
+		generation := self.generation
+		next, _ := self.peek(false)
+		self.lock.Unlock()
+
+		if err := gapless.SetNext(next, func() {
+			go self.advanced(generation, next)
+		}); err != nil {
+			log.Warningf("Cannot prepare the next song: %v", err)
+		}
+
+		self.lock.Lock()
+		current, _ := self.peek(false)
+		stale := (self.generation != generation || current != next)
+		self.lock.Unlock()
+
+		if !stale {
+			return
+		}
+	}
+}
+
+func (self *Player) seek(item *QueueItem, offset time.Duration) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if length := itemLength(item); length > 0 && offset > length {
+		return fmt.Errorf("Seek position is beyond the end of the song")
+	}
+
+	wasPaused := (self.state == StatePaused && item == self.item)
+
+	if err := self.start(item, offset); err != nil {
+		return err
+	}
+
+	if wasPaused {
+		return self.pause()
+	}
+
+	return nil
+}
+
+func (self *Player) start(item *QueueItem, offset time.Duration) error {
+	if offset < item.Start {
+		offset = item.Start
+	}
+
+	self.generation += 1
+	generation := self.generation
+
+	if err := self.renderer.Play(item, offset, func() {
+		go self.finished(generation)
+	}); err != nil {
+		self.err = err
+		self.setState(StateStopped)
+		self.item = nil
+		self.app.AddChangedSubsystem(`player`)
+		return err
+	}
+
+	self.queue.SetCurrent(item.SongID)
+	self.item = item
+	self.setState(StatePlaying)
+	self.elapsed = offset
+	self.resumedAt = time.Now()
+	self.err = nil
+	self.app.AddChangedSubsystem(`player`)
+
+	// let a gapless renderer know what to play once this song ends
+	go self.Refresh()
+
+	return nil
+}
+
+func (self *Player) pause() error {
+	if self.state != StatePlaying {
+		return nil
+	}
+
+	if err := self.renderer.Pause(); err != nil {
+		return err
+	}
+
+	self.elapsed = self.position()
+	self.setState(StatePaused)
+	self.app.AddChangedSubsystem(`player`)
+
+	return nil
+}
+
+func (self *Player) resume() error {
+	if self.state != StatePaused {
+		return nil
+	}
+
+	if err := self.renderer.Resume(); err != nil {
+		return err
+	}
+
+	self.resumedAt = time.Now()
+	self.setState(StatePlaying)
+	self.app.AddChangedSubsystem(`player`)
+
+	return nil
+}
+
+func (self *Player) stop() error {
+	if self.state == StateStopped {
+		return nil
+	}
+
+	self.generation += 1
+
+	if err := self.renderer.Stop(); err != nil {
+		return err
+	}
+
+	self.setState(StateStopped)
+	self.item = nil
+	self.elapsed = 0
+	self.app.AddChangedSubsystem(`player`)
+
+	return nil
+}
+
+// called (asynchronously) by the renderer when a song has played to the end
+func (self *Player) finished(generation uint64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if generation != self.generation || self.state == StateStopped {
+		return
+	}
+
+	mode := self.app.Playmode()
+	previous := self.item
+
+	if next, ok := self.peek(false); ok {
+		self.start(next, 0)
+	} else {
+		self.stop()
+
+		// in single mode we stay on the song that just played; otherwise we've reached the end of
+		// the queue and there is no current song anymore
+		if mode.Single == SingleOff {
+			self.queue.SetCurrent(0)
+		}
+	}
+
+	self.consume(previous)
+
+	if mode.Single == SingleOneshot {
+		self.app.UpdatePlaymode(func(mode *Playmode) error {
+			mode.Single = SingleOff
+			return nil
+		})
+	}
+}
+
+// called (asynchronously) by gapless renderers once they've moved on to playing the given item
+func (self *Player) advanced(generation uint64, item *QueueItem) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if generation != self.generation || self.state == StateStopped {
+		return
+	}
+
+	mode := self.app.Playmode()
+	previous := self.item
+
+	self.queue.SetCurrent(item.SongID)
+	self.item = item
+	self.elapsed = item.Start
+	self.resumedAt = time.Now()
+	self.app.AddChangedSubsystem(`player`)
+
+	self.consume(previous)
+
+	if mode.Single == SingleOneshot {
+		self.app.UpdatePlaymode(func(mode *Playmode) error {
+			mode.Single = SingleOff
+			return nil
+		})
+	}
+
+	go self.Refresh()
+}
+
+// Picks the song that follows the current one.  If manual is true, the user has explicitly asked
+// to skip ahead, so single mode (which is about what happens when a song ends) doesn't apply.
+func (self *Player) peek(manual bool) (*QueueItem, bool) {
+	mode := self.app.Playmode()
+	current, ok := self.queue.Current()
+
+	if !ok {
+		return nil, false
+	}
+
+	if !manual && mode.Single != SingleOff {
+		if mode.Repeat && !mode.Consume {
+			return current, true
+		}
+
+		return nil, false
+	}
+
+	order := self.order(mode)
+	pos := indexOf(order, current)
+
+	if pos < 0 {
+		return nil, false
+	} else if pos+1 < len(order) {
+		return order[pos+1], true
+	} else if mode.Repeat && len(order) > 0 {
+		// the current song is about to be consumed, so it can't also be the next one
+		if mode.Consume && order[0] == current {
+			return nil, false
+		}
+
+		return order[0], true
+	}
+
+	return nil, false
+}
+
+// Returns the items in the queue in the order they are to be played in.  In random mode, this is
+// a shuffled order (generated using the mode's EntryOrder) that begins with the current song; it
+// is regenerated whenever the queue changes.
+func (self *Player) order(mode Playmode) []*QueueItem {
+	items := self.queue.Items()
+
+	if !mode.Random {
+		return items
+	}
+
+	if version := self.queue.Version(); self.shuffled == nil || self.shuffledAt != version || self.shuffledBy != mode.Order() {
+		self.shuffle(items, mode.Order())
+		self.shuffledAt = version
+		self.shuffledBy = mode.Order()
+	}
+
+	byID := make(map[library.EntryID]*QueueItem)
+
+	for _, item := range items {
+		byID[item.SongID] = item
+	}
+
+	order := make([]*QueueItem, 0, len(items))
+
+	for _, id := range self.shuffled {
+		if item, ok := byID[id]; ok {
+			order = append(order, item)
+		}
+	}
+
+	return order
+}
+
+func (self *Player) shuffle(items []*QueueItem, by library.EntryOrder) {
+	entries := make(library.EntryList, len(items))
+	ids := make(map[*library.Entry]library.EntryID)
+
+	// reorder copies of the entries, since Reorder modifies the entries it sorts
+	for i, item := range items {
+		entry := *item.Entry
+		entries[i] = &entry
+		ids[&entry] = item.SongID
+	}
+
+	entries.Reorder(by)
+
+	self.shuffled = make([]library.EntryID, 0, len(entries))
+
+	if current, ok := self.queue.Current(); ok {
+		self.shuffled = append(self.shuffled, current.SongID)
+	}
+
+	for _, entry := range entries {
+		if id := ids[entry]; len(self.shuffled) == 0 || id != self.shuffled[0] {
+			self.shuffled = append(self.shuffled, id)
+		}
+	}
+}
+
+// in consume mode, removes the given song from the queue once it's done playing
+func (self *Player) consume(item *QueueItem) {
+	if item == nil || !self.app.Playmode().Consume {
+		return
+	}
+
+	self.queue.RemoveID(item.SongID)
+}
+
+// changes the playback state, keeping track of the time spent playing
+func (self *Player) setState(state PlayerState) {
+	if self.state == StatePlaying && state != StatePlaying {
+		self.playtime += time.Since(self.playingAt)
+	} else if self.state != StatePlaying && state == StatePlaying {
+		self.playingAt = time.Now()
+	}
+
+	self.state = state
+}
+
+func (self *Player) position() time.Duration {
+	switch self.state {
+	case StatePlaying:
+		// time.Since uses the monotonic clock reading embedded in resumedAt, so wall clock
+		// adjustments don't affect the reported position
+		return self.elapsed + time.Since(self.resumedAt)
+	case StatePaused:
+		return self.elapsed
+	default:
+		return 0
+	}
+}
+
+func indexOf(items []*QueueItem, item *QueueItem) int {
+	if item != nil {
+		for i, candidate := range items {
+			if candidate == item {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Returns the position at which playback of the given item ends.
+func itemLength(item *QueueItem) time.Duration {
+	if item.End > 0 {
+		return item.End
+	}
+
+	return item.Duration()
+}
+
+// The nullRenderer produces no audio, but otherwise behaves like a real output: items "finish"
+// once their duration has elapsed in real time.
+type nullRenderer struct {
+	timer     *time.Timer
+	finished  func()
+	remaining time.Duration
+	startedAt time.Time
+	lock      sync.Mutex
+}
+
+func (self *nullRenderer) Play(item *QueueItem, offset time.Duration, finished func()) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.reset()
+
+	if length := itemLength(item); length > offset {
+		self.finished = finished
+		self.remaining = length - offset
+		self.startedAt = time.Now()
+		self.timer = time.AfterFunc(self.remaining, finished)
+	}
+
+	return nil
+}
+
+func (self *nullRenderer) Pause() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.timer != nil && self.timer.Stop() {
+		self.remaining -= time.Since(self.startedAt)
+		self.timer = nil
+	}
+
+	return nil
+}
+
+func (self *nullRenderer) Resume() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.timer == nil && self.finished != nil && self.remaining > 0 {
+		self.startedAt = time.Now()
+		self.timer = time.AfterFunc(self.remaining, self.finished)
+	}
+
+	return nil
+}
+
+func (self *nullRenderer) Stop() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.reset()
+	return nil
+}
+
+func (self *nullRenderer) reset() {
+	if self.timer != nil {
+		self.timer.Stop()
+	}
+
+	self.timer = nil
+	self.finished = nil
+	self.remaining = 0
+}
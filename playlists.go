@@ -0,0 +1,262 @@
+package moped
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/pathutil"
+)
+
+// the extension stored playlists are saved with
+var PlaylistExtension = `.m3u`
+
+// A StoredPlaylist is the name of a playlist file, and when it was last changed.
+type StoredPlaylist struct {
+	Name         string
+	LastModified time.Time
+}
+
+func (self *StoredPlaylist) String() string {
+	out := fmt.Sprintf("playlist: %v\n", self.Name)
+	out += fmt.Sprintf("Last-Modified: %v\n", self.LastModified.UTC().Format(time.RFC3339))
+
+	return out
+}
+
+// The PlaylistStore keeps stored playlists as .m3u files in a directory.  Each line of a playlist
+// is the path of a song as it appears to clients (i.e.: starting with the name of its library),
+// so that it can be resolved with Moped.Get.
+type PlaylistStore struct {
+	directory string
+	lock      sync.Mutex
+}
+
+func NewPlaylistStore(directory string) (*PlaylistStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &PlaylistStore{
+		directory: directory,
+	}, nil
+}
+
+// Sets the directory that stored playlists are kept in, creating it if it doesn't exist.
+func (self *Moped) SetPlaylistDirectory(directory string) error {
+	if directory == `` {
+		return nil
+	}
+
+	if expanded, err := pathutil.ExpandUser(directory); err == nil {
+		directory = expanded
+	} else {
+		return err
+	}
+
+	if store, err := NewPlaylistStore(directory); err == nil {
+		self.playlists = store
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns all stored playlists, sorted by name.
+func (self *PlaylistStore) List() ([]*StoredPlaylist, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	infos, err := ioutil.ReadDir(self.directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	playlists := make([]*StoredPlaylist, 0)
+
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), `.`) || filepath.Ext(info.Name()) != PlaylistExtension {
+			continue
+		}
+
+		playlists = append(playlists, &StoredPlaylist{
+			Name:         strings.TrimSuffix(info.Name(), PlaylistExtension),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	sort.Slice(playlists, func(i, j int) bool {
+		return playlists[i].Name < playlists[j].Name
+	})
+
+	return playlists, nil
+}
+
+// Returns the paths of the songs in the given playlist.
+func (self *PlaylistStore) Load(name string) ([]string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	uris, err := self.read(name)
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No such playlist")
+	}
+
+	return uris, err
+}
+
+// Writes the given song paths to a playlist, replacing it if it already exists.
+func (self *PlaylistStore) Save(name string, uris []string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.write(name, uris)
+}
+
+// Writes the given song paths to a new playlist, which fails if one with that name already
+// exists.
+func (self *PlaylistStore) Create(name string, uris []string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if filename, err := self.filename(name); err == nil {
+		if _, err := os.Stat(filename); err == nil {
+			return fmt.Errorf("Playlist already exists")
+		}
+	} else {
+		return err
+	}
+
+	return self.write(name, uris)
+}
+
+// Changes the contents of a playlist.  The given function is passed the songs in the playlist
+// (which are empty if it doesn't exist and create is set) and returns what they should be
+// replaced with.
+func (self *PlaylistStore) Update(name string, create bool, fn func(uris []string) ([]string, error)) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	uris, err := self.read(name)
+
+	if os.IsNotExist(err) {
+		if create {
+			uris, err = make([]string, 0), nil
+		} else {
+			return fmt.Errorf("No such playlist")
+		}
+	}
+
+	if err == nil {
+		if uris, err = fn(uris); err == nil {
+			return self.write(name, uris)
+		}
+	}
+
+	return err
+}
+
+// Renames a playlist, which fails if a playlist with the new name already exists.
+func (self *PlaylistStore) Rename(from string, to string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if source, err := self.filename(from); err == nil {
+		if dest, err := self.filename(to); err == nil {
+			if _, err := os.Stat(source); os.IsNotExist(err) {
+				return fmt.Errorf("No such playlist")
+			} else if _, err := os.Stat(dest); err == nil {
+				return fmt.Errorf("Playlist already exists")
+			}
+
+			return os.Rename(source, dest)
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+// Removes a playlist.
+func (self *PlaylistStore) Delete(name string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if filename, err := self.filename(name); err == nil {
+		if err := os.Remove(filename); os.IsNotExist(err) {
+			return fmt.Errorf("No such playlist")
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func (self *PlaylistStore) read(name string) ([]string, error) {
+	filename, err := self.filename(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uris := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		// comments (including the #EXTM3U header and #EXTINF lines) are skipped
+		if line := strings.TrimSpace(scanner.Text()); line != `` && !strings.HasPrefix(line, `#`) {
+			uris = append(uris, line)
+		}
+	}
+
+	return uris, scanner.Err()
+}
+
+// writes to a temporary file that replaces the playlist once it's complete, so that a failed
+// write doesn't leave a truncated playlist behind
+func (self *PlaylistStore) write(name string, uris []string) error {
+	filename, err := self.filename(name)
+
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+
+	for _, uri := range uris {
+		data.WriteString(uri + "\n")
+	}
+
+	tmp := filepath.Join(filepath.Dir(filename), `.`+filepath.Base(filename)+`.tmp`)
+
+	if err := ioutil.WriteFile(tmp, data.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+func (self *PlaylistStore) filename(name string) (string, error) {
+	if name == `` || strings.HasPrefix(name, `.`) || strings.ContainsAny(name, "/\\\r\n") {
+		return ``, fmt.Errorf("Invalid playlist name %q", name)
+	}
+
+	return filepath.Join(self.directory, name+PlaylistExtension), nil
+}
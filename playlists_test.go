@@ -0,0 +1,65 @@
+package moped
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPlaylistStoreCreate(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `moped-playlists`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := NewPlaylistStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of several concurrent creates of the same playlist should succeed
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			results <- store.Create(`mix`, []string{`music/a.flac`})
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	created := 0
+
+	for err := range results {
+		if err == nil {
+			created += 1
+		} else if err.Error() != `Playlist already exists` {
+			t.Errorf("Create() failed: %v", err)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("playlist was created %d times, want 1", created)
+	}
+
+	if uris, err := store.Load(`mix`); err != nil {
+		t.Fatal(err)
+	} else if want := []string{`music/a.flac`}; !reflect.DeepEqual(uris, want) {
+		t.Errorf("Load() = %q, want %q", uris, want)
+	}
+
+	if err := store.Create(`../mix`, nil); err == nil {
+		t.Errorf("Create() accepted an invalid name")
+	}
+}
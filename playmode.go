@@ -0,0 +1,130 @@
+package moped
+
+import (
+	"fmt"
+
+	"github.com/ghetzel/moped/audio"
+	"github.com/ghetzel/moped/library"
+)
+
+type SingleMode int
+
+const (
+	SingleOff SingleMode = iota
+	SingleOn
+	SingleOneshot
+)
+
+func ParseSingleMode(value string) (SingleMode, error) {
+	switch value {
+	case `0`:
+		return SingleOff, nil
+	case `1`:
+		return SingleOn, nil
+	case `oneshot`:
+		return SingleOneshot, nil
+	default:
+		return SingleOff, fmt.Errorf("Invalid single mode %q", value)
+	}
+}
+
+func (self SingleMode) String() string {
+	switch self {
+	case SingleOn:
+		return `1`
+	case SingleOneshot:
+		return `oneshot`
+	default:
+		return `0`
+	}
+}
+
+// The set of options that affect which song is played next (and how).
+type Playmode struct {
+	// Start over from the beginning of the queue once the end is reached.
+	Repeat bool `json:"repeat"`
+
+	// Play the queue in a random order.
+	Random bool `json:"random"`
+
+	// How to randomize the queue when Random is set; must be one of the random EntryOrders.
+	RandomOrder library.EntryOrder `json:"random_order,omitempty"`
+
+	// Stop after the current song (or, with Repeat, play it over and over).  SingleOneshot only
+	// applies to the current song, after which single mode is switched off.
+	Single SingleMode `json:"single"`
+
+	// Remove songs from the queue once they've been played.
+	Consume bool `json:"consume"`
+
+	// The number of seconds to crossfade between songs.
+	Crossfade int `json:"crossfade"`
+
+	// The volume (in dB) at which songs are overlapped when using MixRamp.
+	MixRampDB float64 `json:"mixrampdb"`
+
+	// Whether MixRamp is used to decide how much songs overlap (when they have MixRamp tags).  This
+	// is what MPD reports as a mixrampdelay of "nan" when switched off.
+	MixRamp bool `json:"mixramp"`
+
+	// How many seconds to subtract from the MixRamp overlap.
+	MixRampDelay float64 `json:"mixrampdelay"`
+
+	// Which ReplayGain values (if any) are used to normalize the volume of songs.
+	ReplayGainMode audio.ReplayGainMode `json:"replay_gain_mode,omitempty"`
+}
+
+// Returns the order that the queue is played in when Random is set.
+func (self Playmode) Order() library.EntryOrder {
+	if self.RandomOrder != library.OrderLinear {
+		return self.RandomOrder
+	}
+
+	return library.OrderRandom
+}
+
+// Returns whether the given order can be used as the ordering for random mode.
+func IsRandomOrder(order library.EntryOrder) bool {
+	switch order {
+	case library.OrderRandom,
+		library.OrderRandomGroupArtists,
+		library.OrderRandomGroupAlbums,
+		library.OrderRandomGroupYears:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the current playback options.
+func (self *Partition) Playmode() Playmode {
+	self.playmodeLock.RLock()
+	defer self.playmodeLock.RUnlock()
+
+	return self.playmode
+}
+
+// Modifies the playback options with the given function.  If anything changed, clients are told
+// about it and the new options are saved.
+func (self *Partition) UpdatePlaymode(fn func(mode *Playmode) error) error {
+	self.playmodeLock.Lock()
+	mode := self.playmode
+
+	if err := fn(&mode); err != nil {
+		self.playmodeLock.Unlock()
+		return err
+	}
+
+	changed := (mode != self.playmode)
+	self.playmode = mode
+	self.playmodeLock.Unlock()
+
+	if changed {
+		self.AddChangedSubsystem(`options`)
+		self.saveStateOrWarn()
+
+		go self.player.Refresh()
+	}
+
+	return nil
+}
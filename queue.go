@@ -0,0 +1,417 @@
+package moped
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/moped/library"
+)
+
+// A QueueItem is a single song in the play queue.  Every item is assigned a song ID when it is
+// added, which stays the same for as long as the item remains in the queue (even if it is moved
+// around or the same file is enqueued more than once).
+type QueueItem struct {
+	*library.Entry
+	SongID   library.EntryID
+	Priority int
+	Start    time.Duration
+	End      time.Duration
+}
+
+// Returns whether a playback range (as set by the "rangeid" command) is set on this item.
+func (self *QueueItem) HasRange() bool {
+	return (self.Start > 0 || self.End > 0)
+}
+
+// The play queue (what MPD calls "the current playlist").
+type Queue struct {
+	app     *Partition
+	items   []*QueueItem
+	current library.EntryID
+	lastID  library.EntryID
+	version uint32
+	lock    sync.RWMutex
+}
+
+func NewQueue(app *Partition) *Queue {
+	return &Queue{
+		app:     app,
+		items:   make([]*QueueItem, 0),
+		version: 1,
+	}
+}
+
+// Returns the number of items in the queue.
+func (self *Queue) Len() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return len(self.items)
+}
+
+// Returns the playlist version, which is incremented every time the queue is modified.
+func (self *Queue) Version() uint32 {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.version
+}
+
+// Returns a copy of the items currently in the queue.
+func (self *Queue) Items() []*QueueItem {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	items := make([]*QueueItem, len(self.items))
+	copy(items, self.items)
+
+	return items
+}
+
+// Retrieve the item at the given position.
+func (self *Queue) Get(pos int) (*QueueItem, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if pos >= 0 && pos < len(self.items) {
+		return self.items[pos], true
+	}
+
+	return nil, false
+}
+
+// Retrieve the item with the given song ID.
+func (self *Queue) GetID(id library.EntryID) (*QueueItem, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if pos := self.position(id); pos >= 0 {
+		return self.items[pos], true
+	}
+
+	return nil, false
+}
+
+// Returns the position of the item with the given song ID, or -1 if no such item exists.
+func (self *Queue) Position(id library.EntryID) int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.position(id)
+}
+
+// Returns the position of the current song, or -1 if there is no current song.
+func (self *Queue) Index() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.position(self.current)
+}
+
+// Returns the current song (the one that is playing, paused, or that playback stopped on).
+func (self *Queue) Current() (*QueueItem, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if pos := self.position(self.current); pos >= 0 {
+		return self.items[pos], true
+	}
+
+	return nil, false
+}
+
+// Sets the current song to the one with the given song ID; a zero ID unsets the current song.
+func (self *Queue) SetCurrent(id library.EntryID) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.current = id
+}
+
+// Resolves the given URI (a song, or a directory to add recursively) and appends the resulting
+// songs to the end of the queue.  If position is zero or greater, the songs are inserted at
+// that position instead.  The IDs of the newly-added songs are returned.
+func (self *Queue) Add(uri string, position int) ([]library.EntryID, error) {
+	entries, err := self.resolve(uri)
+
+	if err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		return nil, fmt.Errorf("No songs found at %q", uri)
+	}
+
+	return self.AddEntries(entries, position)
+}
+
+// Inserts the given (already resolved) songs at the given position, or appends them if position
+// is negative.  Returns the song IDs assigned to them.
+func (self *Queue) AddEntries(entries library.EntryList, position int) ([]library.EntryID, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if position < 0 {
+		position = len(self.items)
+	} else if position > len(self.items) {
+		return nil, fmt.Errorf("Bad song index")
+	}
+
+	added := make([]*QueueItem, len(entries))
+	ids := make([]library.EntryID, len(entries))
+
+	for i, entry := range entries {
+		self.lastID += 1
+
+		added[i] = &QueueItem{
+			Entry:  entry,
+			SongID: self.lastID,
+		}
+
+		ids[i] = self.lastID
+	}
+
+	items := make([]*QueueItem, 0, len(self.items)+len(added))
+	items = append(items, self.items[:position]...)
+	items = append(items, added...)
+	items = append(items, self.items[position:]...)
+
+	self.items = items
+	self.changed()
+
+	return ids, nil
+}
+
+// Removes all items from the queue.
+func (self *Queue) Clear() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.items = make([]*QueueItem, 0)
+	self.current = 0
+	self.changed()
+}
+
+// Removes the items in the range [start, end) from the queue.  An end value less than zero
+// means "until the end of the queue".
+func (self *Queue) Remove(start int, end int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if start, end, err := self.bounds(start, end); err == nil {
+		self.remove(start, end)
+		self.changed()
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Removes the item with the given song ID from the queue.
+func (self *Queue) RemoveID(id library.EntryID) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if pos := self.position(id); pos >= 0 {
+		self.remove(pos, pos+1)
+		self.changed()
+		return nil
+	} else {
+		return fmt.Errorf("No such song")
+	}
+}
+
+// Moves the items in the range [start, end) so that the first of them ends up at position "to".
+func (self *Queue) Move(start int, end int, to int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	start, end, err := self.bounds(start, end)
+
+	if err != nil {
+		return err
+	} else if to < 0 || to+(end-start) > len(self.items) {
+		return fmt.Errorf("Bad song index")
+	}
+
+	self.move(start, end, to)
+	self.changed()
+
+	return nil
+}
+
+// Moves the item with the given song ID to position "to".
+func (self *Queue) MoveID(id library.EntryID, to int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if pos := self.position(id); pos < 0 {
+		return fmt.Errorf("No such song")
+	} else if to < 0 || to >= len(self.items) {
+		return fmt.Errorf("Bad song index")
+	} else {
+		self.move(pos, pos+1, to)
+		self.changed()
+		return nil
+	}
+}
+
+// Swaps the positions of the items at positions a and b.
+func (self *Queue) Swap(a int, b int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if a < 0 || a >= len(self.items) || b < 0 || b >= len(self.items) {
+		return fmt.Errorf("Bad song index")
+	}
+
+	self.items[a], self.items[b] = self.items[b], self.items[a]
+	self.changed()
+
+	return nil
+}
+
+// Swaps the positions of the items with the given song IDs.
+func (self *Queue) SwapID(a library.EntryID, b library.EntryID) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	aPos := self.position(a)
+	bPos := self.position(b)
+
+	if aPos < 0 || bPos < 0 {
+		return fmt.Errorf("No such song")
+	}
+
+	self.items[aPos], self.items[bPos] = self.items[bPos], self.items[aPos]
+	self.changed()
+
+	return nil
+}
+
+// Randomly reorders the items in the range [start, end).
+func (self *Queue) Shuffle(start int, end int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if start, end, err := self.bounds(start, end); err == nil {
+		subset := self.items[start:end]
+
+		rand.Shuffle(len(subset), func(i, j int) {
+			subset[i], subset[j] = subset[j], subset[i]
+		})
+
+		self.changed()
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Restricts playback of the item with the given song ID to the portion between start and end.
+// Zero values mean "from the beginning" and "until the end", respectively.
+func (self *Queue) SetRange(id library.EntryID, start time.Duration, end time.Duration) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if end > 0 && end <= start {
+		return fmt.Errorf("Bad range")
+	}
+
+	if pos := self.position(id); pos >= 0 {
+		self.items[pos].Start = start
+		self.items[pos].End = end
+		self.changed()
+		return nil
+	} else {
+		return fmt.Errorf("No such song")
+	}
+}
+
+func (self *Queue) resolve(uri string) (library.EntryList, error) {
+	// URLs aren't in any library, so they're queued up as they are
+	if library.IsRemoteLocation(uri) {
+		return library.EntryList{library.NewRemoteEntry(uri)}, nil
+	}
+
+	entries := make(library.EntryList, 0)
+
+	if err := self.app.Walk(uri, func(entry *library.Entry) error {
+		if entry.IsContent() {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (self *Queue) position(id library.EntryID) int {
+	if id > 0 {
+		for i, item := range self.items {
+			if item.SongID == id {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func (self *Queue) bounds(start int, end int) (int, int, error) {
+	if end < 0 {
+		end = len(self.items)
+	}
+
+	if start < 0 || start >= len(self.items) || end > len(self.items) || end <= start {
+		return 0, 0, fmt.Errorf("Bad song index")
+	}
+
+	return start, end, nil
+}
+
+func (self *Queue) remove(start int, end int) {
+	var removedCurrent bool
+
+	for _, item := range self.items[start:end] {
+		if item.SongID == self.current {
+			removedCurrent = true
+		}
+	}
+
+	self.items = append(self.items[:start], self.items[end:]...)
+
+	// if the current song was removed, the song that followed it becomes the current one
+	if removedCurrent {
+		if start < len(self.items) {
+			self.current = self.items[start].SongID
+		} else {
+			self.current = 0
+		}
+	}
+}
+
+func (self *Queue) move(start int, end int, to int) {
+	moving := make([]*QueueItem, end-start)
+	copy(moving, self.items[start:end])
+
+	rest := append(self.items[:start:start], self.items[end:]...)
+	items := make([]*QueueItem, 0, len(self.items))
+	items = append(items, rest[:to]...)
+	items = append(items, moving...)
+	items = append(items, rest[to:]...)
+
+	self.items = items
+}
+
+func (self *Queue) changed() {
+	self.version += 1
+	self.app.AddChangedSubsystem(`playlist`)
+
+	// what plays next may have changed; this happens in the background because the player may
+	// be the one modifying the queue
+	go self.app.player.Refresh()
+}
@@ -0,0 +1,233 @@
+package moped
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/pathutil"
+)
+
+type OutputState struct {
+	Enabled    bool              `json:"enabled"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// The state of a partition other than the default one, and the names of the outputs in it.
+type PartitionState struct {
+	Volume   int      `json:"volume"`
+	Playmode Playmode `json:"playmode"`
+	Outputs  []string `json:"outputs,omitempty"`
+}
+
+// The State holds everything that should survive a restart of the daemon.  The volume and playback
+// options are those of the default partition, and mounts are the storage URIs of the libraries
+// that were mounted at runtime.
+type State struct {
+	Volume     *int                       `json:"volume,omitempty"`
+	Playmode   *Playmode                  `json:"playmode,omitempty"`
+	Outputs    map[string]*OutputState    `json:"outputs,omitempty"`
+	Partitions map[string]*PartitionState `json:"partitions,omitempty"`
+	Mounts     map[string]string          `json:"mounts,omitempty"`
+}
+
+// Sets the file that runtime state is persisted to, and restores any state previously saved there.
+// This should be called after all outputs have been added.
+func (self *Moped) SetStateFile(filename string) error {
+	if filename == `` {
+		return nil
+	}
+
+	if expanded, err := pathutil.ExpandUser(filename); err == nil {
+		filename = expanded
+	} else {
+		return err
+	}
+
+	// the state file is only set once the state has been restored, since restoring it changes things
+	// that would otherwise save a half-restored state over it
+	defer func() {
+		self.stateLock.Lock()
+		self.stateFile = filename
+		self.stateLock.Unlock()
+	}()
+
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		var state State
+
+		if err := json.Unmarshal(data, &state); err == nil {
+			return self.restoreState(&state)
+		} else {
+			return err
+		}
+	} else if os.IsNotExist(err) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (self *Moped) restoreState(state *State) error {
+	names := maputil.StringKeys(state.Mounts)
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := self.Mount(name, state.Mounts[name]); err != nil {
+			log.Warningf("Cannot restore mount %q: %v", name, err)
+
+			// the storage may just be missing for now (e.g.: a USB stick that isn't plugged in), so
+			// it's remembered and tried again the next time
+			self.librariesLock.Lock()
+			self.failedMounts[name] = state.Mounts[name]
+			self.librariesLock.Unlock()
+		}
+	}
+
+	for name, outstate := range state.Outputs {
+		if device, ok := self.outputs.GetByName(name); ok {
+			if err := device.SetEnabled(outstate.Enabled); err != nil {
+				return err
+			}
+
+			for attr, value := range outstate.Attributes {
+				if err := device.SetAttribute(attr, value); err != nil {
+					log.Warningf("Cannot restore attribute %q on output %q: %v", attr, name, err)
+				}
+			}
+		}
+	}
+
+	def := self.DefaultPartition()
+
+	if state.Volume != nil {
+		if err := def.mixer.SetVolume(*state.Volume); err != nil {
+			log.Warningf("Cannot restore volume: %v", err)
+		}
+	}
+
+	if state.Playmode != nil {
+		def.UpdatePlaymode(func(mode *Playmode) error {
+			*mode = *state.Playmode
+			return nil
+		})
+	}
+
+	for name, pstate := range state.Partitions {
+		partition, ok := self.Partition(name)
+
+		if !ok {
+			var err error
+
+			if partition, err = self.CreatePartition(name); err != nil {
+				log.Warningf("Cannot restore partition %q: %v", name, err)
+				continue
+			}
+		}
+
+		if err := partition.mixer.SetVolume(pstate.Volume); err != nil {
+			log.Warningf("Cannot restore volume of partition %q: %v", name, err)
+		}
+
+		partition.UpdatePlaymode(func(mode *Playmode) error {
+			*mode = pstate.Playmode
+			return nil
+		})
+
+		for _, output := range pstate.Outputs {
+			if err := self.MoveOutput(output, partition); err != nil {
+				log.Warningf("Cannot restore output %q to partition %q: %v", output, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self *Moped) currentState() *State {
+	def := self.DefaultPartition()
+	volume := def.mixer.Volume()
+	mode := def.Playmode()
+
+	state := &State{
+		Volume:     &volume,
+		Playmode:   &mode,
+		Outputs:    make(map[string]*OutputState),
+		Partitions: make(map[string]*PartitionState),
+		Mounts:     self.Mounts(),
+	}
+
+	self.librariesLock.RLock()
+
+	for name, uri := range self.failedMounts {
+		state.Mounts[name] = uri
+	}
+
+	self.librariesLock.RUnlock()
+
+	for _, device := range self.outputs.List() {
+		state.Outputs[device.Name] = &OutputState{
+			Enabled:    device.IsEnabled(),
+			Attributes: device.Attributes(),
+		}
+	}
+
+	for _, partition := range self.Partitions() {
+		if partition == def {
+			continue
+		}
+
+		pstate := &PartitionState{
+			Volume:   partition.mixer.Volume(),
+			Playmode: partition.Playmode(),
+			Outputs:  make([]string, 0),
+		}
+
+		for _, device := range partition.outputs.List() {
+			pstate.Outputs = append(pstate.Outputs, device.Name)
+		}
+
+		state.Partitions[partition.Name] = pstate
+	}
+
+	return state
+}
+
+// Writes the current state to the state file (if one is set).
+func (self *Moped) SaveState() error {
+	self.stateLock.Lock()
+	defer self.stateLock.Unlock()
+
+	if self.stateFile == `` {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(self.currentState(), ``, `  `)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(self.stateFile), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file and move it into place so that a crash never leaves a
+	// half-written state file behind
+	tmp := self.stateFile + `.tmp`
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, self.stateFile)
+}
+
+func (self *Moped) saveStateOrWarn() {
+	if err := self.SaveState(); err != nil {
+		log.Warningf("Failed to save state: %v", err)
+	}
+}
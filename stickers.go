@@ -0,0 +1,181 @@
+package moped
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Stickers are name/value pairs that clients attach to objects (such as songs, which are referred
+// to by their URI) to keep track of things like ratings and play counts.  They are kept in the
+// "stickers" bucket of the database, which has a bucket for each type of object, in which each
+// object has a bucket of its stickers.  Since they're kept apart from the libraries, stickers
+// survive updates and rescans.
+var dbStickersBucket = []byte(`stickers`)
+
+// The types of objects that stickers can be attached to.
+var StickerTypes = []string{
+	`song`,
+}
+
+// Returns the value of a sticker, and whether it exists.
+func (self *Database) Sticker(objtype string, uri string, name string) (string, bool, error) {
+	var value string
+	var found bool
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if bucket := self.stickerBucket(tx, objtype, uri); bucket != nil {
+			if v := bucket.Get([]byte(name)); v != nil {
+				value = string(v)
+				found = true
+			}
+		}
+
+		return nil
+	})
+
+	return value, found, err
+}
+
+// Returns all of the stickers attached to an object.
+func (self *Database) Stickers(objtype string, uri string) (map[string]string, error) {
+	stickers := make(map[string]string)
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if bucket := self.stickerBucket(tx, objtype, uri); bucket != nil {
+			return bucket.ForEach(func(key []byte, value []byte) error {
+				stickers[string(key)] = string(value)
+				return nil
+			})
+		}
+
+		return nil
+	})
+
+	return stickers, err
+}
+
+// Changes the value of a sticker.  The given function is passed the current value (and whether
+// there is one), and returns the new value.
+func (self *Database) UpdateSticker(objtype string, uri string, name string, fn func(value string, found bool) (string, error)) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(dbStickersBucket)
+
+		if err == nil {
+			if bucket, err = bucket.CreateBucketIfNotExists([]byte(objtype)); err == nil {
+				bucket, err = bucket.CreateBucketIfNotExists([]byte(uri))
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		current := bucket.Get([]byte(name))
+
+		if value, err := fn(string(current), (current != nil)); err == nil {
+			return bucket.Put([]byte(name), []byte(value))
+		} else {
+			return err
+		}
+	})
+}
+
+// Removes a sticker from an object, or all of them if name is empty.  Returns whether there was
+// anything to remove.
+func (self *Database) DeleteSticker(objtype string, uri string, name string) (bool, error) {
+	var found bool
+
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		if bucket := self.stickerBucket(tx, objtype, uri); bucket != nil {
+			if name != `` {
+				if found = (bucket.Get([]byte(name)) != nil); found {
+					if err := bucket.Delete([]byte(name)); err != nil {
+						return err
+					}
+				}
+
+				// objects are forgotten once they have no stickers left
+				if key, _ := bucket.Cursor().First(); key != nil {
+					return nil
+				}
+			} else {
+				found = true
+			}
+
+			return tx.Bucket(dbStickersBucket).Bucket([]byte(objtype)).DeleteBucket([]byte(uri))
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// Returns the value of the named sticker on every object at or below the given URI that has it,
+// keyed by the URI of the object.
+func (self *Database) FindStickers(objtype string, base string, name string) (map[string]string, error) {
+	found := make(map[string]string)
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(dbStickersBucket); bucket != nil {
+			if bucket = bucket.Bucket([]byte(objtype)); bucket != nil {
+				cursor := bucket.Cursor()
+				prefix := []byte(base)
+
+				for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+					uri := string(key)
+
+					if base != `` && uri != base && !strings.HasPrefix(uri, base+`/`) {
+						continue
+					}
+
+					if stickers := bucket.Bucket(key); stickers != nil {
+						if value := stickers.Get([]byte(name)); value != nil {
+							found[uri] = string(value)
+						}
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+func (self *Database) stickerBucket(tx *bolt.Tx, objtype string, uri string) *bolt.Bucket {
+	bucket := tx.Bucket(dbStickersBucket)
+
+	for _, key := range []string{objtype, uri} {
+		if bucket == nil {
+			break
+		}
+
+		bucket = bucket.Bucket([]byte(key))
+	}
+
+	return bucket
+}
+
+// Returns the URI that the stickers of the given object are kept under.  Songs are referred to by
+// their path, which always starts with the name of the library they're in (so that libraries with
+// the same layout don't share stickers).
+func (self *Moped) stickerURI(objtype string, uri string) (string, error) {
+	switch objtype {
+	case `song`:
+		if name, rest, _, ok := self.GetLibraryForPath(uri); ok {
+			return path.Join(name, path.Clean(`/`+rest)), nil
+		} else if name == `` {
+			return ``, nil
+		} else {
+			return ``, fmt.Errorf("No such library '%v'", name)
+		}
+	default:
+		return ``, fmt.Errorf("Unsupported sticker type %q", objtype)
+	}
+}
@@ -0,0 +1,116 @@
+package moped
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// how many update jobs can be waiting to run at once
+var MaxPendingUpdates = 32
+
+type updateJob struct {
+	ID     int
+	Paths  []string
+	Rescan bool
+}
+
+// The Updater runs the jobs started by the "update" and "rescan" commands, one at a time and in
+// the order they were started.
+type Updater struct {
+	app     *Moped
+	lastID  int
+	running int
+	pending []*updateJob
+	lock    sync.Mutex
+}
+
+func NewUpdater(app *Moped) *Updater {
+	return &Updater{
+		app:     app,
+		pending: make([]*updateJob, 0),
+	}
+}
+
+// Queues up an update of the given paths (or every library, if a path is empty) and returns the
+// ID of the job.  If rescan is set, entries are re-read even if they haven't changed.
+func (self *Updater) Start(rescan bool, entryPaths ...string) (int, error) {
+	for _, entryPath := range entryPaths {
+		if entryPath != `` {
+			if _, _, _, ok := self.app.GetLibraryForPath(entryPath); !ok {
+				return 0, fmt.Errorf("No such library or path %q", entryPath)
+			}
+		}
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if len(self.pending) >= MaxPendingUpdates {
+		return 0, fmt.Errorf("Too many pending updates")
+	}
+
+	self.lastID++
+
+	self.pending = append(self.pending, &updateJob{
+		ID:     self.lastID,
+		Paths:  entryPaths,
+		Rescan: rescan,
+	})
+
+	if self.running == 0 {
+		self.running = self.pending[0].ID
+		go self.run()
+	}
+
+	return self.lastID, nil
+}
+
+// Returns the ID of the job that is currently running, or zero if there isn't one.
+func (self *Updater) Current() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.running
+}
+
+func (self *Updater) run() {
+	for {
+		self.lock.Lock()
+		job := self.pending[0]
+		self.pending = self.pending[1:]
+		self.running = job.ID
+		self.lock.Unlock()
+
+		self.app.AddChangedSubsystem(`update`)
+
+		changed, err := self.app.index.Update(job.Rescan, job.Paths...)
+
+		if err == nil {
+			log.Infof("Update %d of %q finished: %d entries changed", job.ID, job.Paths, changed)
+		} else {
+			log.Errorf("Update %d of %q failed: %v", job.ID, job.Paths, err)
+		}
+
+		// the job is no longer reported as running by the time clients hear that it finished
+		self.lock.Lock()
+		done := (len(self.pending) == 0)
+
+		if done {
+			self.running = 0
+		}
+
+		self.lock.Unlock()
+
+		if err == nil && changed > 0 {
+			self.app.AddChangedSubsystem(`database`)
+		}
+
+		self.app.AddChangedSubsystem(`update`)
+
+		if done {
+			return
+		}
+	}
+}